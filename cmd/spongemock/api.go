@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rjchee/spongemock/mock"
+)
+
+const (
+	apiMaxBodySize   = 64 * 1024
+	apiMaxTextLength = 10000
+)
+
+var (
+	apiMocker = mock.New(mock.PlainTokenizer)
+)
+
+type apiPlugin struct{}
+
+func (p apiPlugin) EnvVariables() []EnvVariable {
+	return nil
+}
+
+func (p apiPlugin) RegisterHandles(m *http.ServeMux) {
+	m.HandleFunc("/api/v1/mock", handleAPIMock)
+	m.HandleFunc("/api/v1/styles", handleAPIStyles)
+	m.HandleFunc("/api/v1/templates", handleAPITemplates)
+}
+
+func (p apiPlugin) Name() string {
+	return "api"
+}
+
+func NewAPIPlugin() WebPlugin {
+	return apiPlugin{}
+}
+
+type apiMockRequest struct {
+	Text     string `json:"text"`
+	Style    string `json:"style"`
+	Seed     *int64 `json:"seed"`
+	Template string `json:"template"`
+	// Image requests a rendered image with the default template when no
+	// template is given.
+	Image bool `json:"image"`
+}
+
+type apiMockResponse struct {
+	Text     string `json:"text"`
+	Style    string `json:"style"`
+	Seed     int64  `json:"seed"`
+	Template string `json:"template,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type apiStyle struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type apiTemplate struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Style       string `json:"style,omitempty"`
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) {
+	output, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("error marshalling response json: %s\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(output)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeAPIJSON(w, status, apiError{fmt.Sprintf(format, args...)})
+}
+
+// mockRequest mocks the request text, filling in the style, template and
+// seed that were used. It returns an HTTP status code and error if the
+// request can't be mocked.
+func mockRequest(req apiMockRequest) (apiMockResponse, int, error) {
+	var res apiMockResponse
+	if strings.TrimSpace(req.Text) == "" {
+		return res, http.StatusBadRequest, errors.New("text is required")
+	}
+	if len([]rune(req.Text)) > apiMaxTextLength {
+		return res, http.StatusBadRequest, fmt.Errorf("text must be at most %d characters", apiMaxTextLength)
+	}
+
+	var templateStyle string
+	if req.Template != "" || req.Image {
+		if memeTemplates == nil {
+			return res, http.StatusServiceUnavailable, errors.New("meme templates are not available")
+		}
+		t := memeTemplates.Default()
+		if req.Template != "" {
+			var ok bool
+			if t, ok = memeTemplates.Lookup(req.Template); !ok {
+				return res, http.StatusBadRequest, fmt.Errorf("unknown template %s", req.Template)
+			}
+		}
+		res.Template = t.Name
+		templateStyle = t.Style
+	}
+
+	style := mock.Spongebob
+	if req.Style != "" {
+		var ok bool
+		if style, ok = mock.LookupStyle(req.Style); !ok {
+			return res, http.StatusBadRequest, fmt.Errorf("unknown style %s", req.Style)
+		}
+	} else if s, ok := mock.LookupStyle(templateStyle); ok {
+		style = s
+	}
+	res.Style = style.Name
+
+	// always report the seed so that the same output can be requested again
+	if req.Seed != nil {
+		res.Seed = *req.Seed
+	} else {
+		res.Seed = rand.Int63()
+	}
+	res.Text = apiMocker.WithStyle(style).MockSeed(req.Text, res.Seed)
+
+	if res.Template != "" {
+		u, err := newMemeURL(res.Text, res.Template)
+		if err != nil {
+			log.Println(err)
+			return res, http.StatusInternalServerError, errors.New("error creating meme image")
+		}
+		res.ImageURL = u
+	}
+	return res, http.StatusOK, nil
+}
+
+func handleAPIMock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeAPIError(w, http.StatusMethodNotAllowed, "want method POST, got %s", r.Method)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if mt, _, err := mime.ParseMediaType(ct); err != nil || mt != "application/json" {
+			writeAPIError(w, http.StatusUnsupportedMediaType, "request body must be application/json")
+			return
+		}
+	}
+
+	if !allowMock(w, r) {
+		writeAPIError(w, http.StatusTooManyRequests, "too many requests, please slow down")
+		return
+	}
+
+	var req apiMockRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBodySize)).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid request body: %s", err)
+		return
+	}
+
+	res, status, err := mockRequest(req)
+	if err != nil {
+		writeAPIError(w, status, "%s", err)
+		return
+	}
+
+	if prefersPlainText(r) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Spongemock-Seed", strconv.FormatInt(res.Seed, 10))
+		if res.ImageURL != "" {
+			w.Header().Set("Link", "<"+res.ImageURL+">; rel=\"related\"")
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(res.Text))
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, res)
+}
+
+func handleAPIStyles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		writeAPIError(w, http.StatusMethodNotAllowed, "want method GET, got %s", r.Method)
+		return
+	}
+	styles := []apiStyle{}
+	for _, s := range mock.Styles() {
+		styles = append(styles, apiStyle{s.Name, s.Description})
+	}
+	writeAPIJSON(w, http.StatusOK, styles)
+}
+
+func handleAPITemplates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		writeAPIError(w, http.StatusMethodNotAllowed, "want method GET, got %s", r.Method)
+		return
+	}
+	if memeTemplates == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, "meme templates are not available")
+		return
+	}
+	templates := []apiTemplate{}
+	for _, t := range memeTemplates.Templates() {
+		templates = append(templates, apiTemplate{t.Name, t.Description, t.Style})
+	}
+	writeAPIJSON(w, http.StatusOK, templates)
+}
+
+// prefersPlainText reports whether the Accept header of r ranks text/plain
+// above JSON. JSON is preferred when both are equally acceptable.
+func prefersPlainText(r *http.Request) bool {
+	var plainQ, jsonQ float64
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return false
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mt {
+		case "text/plain":
+			plainQ = q
+		case "application/json":
+			jsonQ = q
+		case "*/*":
+			if jsonQ == 0 {
+				jsonQ = q
+			}
+		}
+	}
+	return plainQ > jsonQ
+}
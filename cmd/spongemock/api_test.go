@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/rjchee/spongemock/meme"
+)
+
+// setupAPITest loads the meme templates and resets the rate limit, and
+// returns a function undoing it.
+func setupAPITest(t *testing.T) func() {
+	c, err := meme.LoadCatalog("../../static/templates.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	memeTemplates = c
+	memeRecords = newMemoryMemeStore()
+	oldLimiter := mockLimiter
+	mockLimiter = newRateLimiter(mockRateLimit, 1000)
+	return func() {
+		memeTemplates = nil
+		memeRecords = nil
+		mockLimiter = oldLimiter
+	}
+}
+
+func TestHandleAPIMock(t *testing.T) {
+	defer setupAPITest(t)()
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		status      int
+		// want is the mocked text, or part of the error message
+		want     string
+		template string
+	}{
+		{name: "wrong method", method: "GET", status: http.StatusMethodNotAllowed, want: "want method POST"},
+		{name: "wrong content type", contentType: "text/plain", body: `{"text": "hi"}`, status: http.StatusUnsupportedMediaType, want: "application/json"},
+		{name: "invalid json", body: `{"text": `, status: http.StatusBadRequest, want: "invalid request body"},
+		{name: "missing text", body: `{"style": "clap"}`, status: http.StatusBadRequest, want: "text is required"},
+		{name: "blank text", body: `{"text": "  "}`, status: http.StatusBadRequest, want: "text is required"},
+		{name: "long text", body: `{"text": "` + strings.Repeat("a", apiMaxTextLength+1) + `"}`, status: http.StatusBadRequest, want: "at most"},
+		{name: "unknown style", body: `{"text": "hi", "style": "shouting"}`, status: http.StatusBadRequest, want: "unknown style shouting"},
+		{name: "unknown template", body: `{"text": "hi", "template": "nope"}`, status: http.StatusBadRequest, want: "unknown template nope"},
+		{name: "style", body: `{"text": "you can't", "style": "clap"}`, status: http.StatusOK, want: "YOU \U0001F44F CAN'T"},
+		{name: "charset", contentType: "application/json; charset=utf-8", body: `{"text": "hi", "style": "whisper"}`, status: http.StatusOK, want: "hi"},
+		{name: "no content type", contentType: "-", body: `{"text": "HI", "style": "whisper"}`, status: http.StatusOK, want: "hi"},
+		{name: "template style", body: `{"text": "a b", "template": "thumbsup"}`, status: http.StatusOK, want: "A \U0001F44F B", template: "thumbsup"},
+		{name: "default image", body: `{"text": "HI", "style": "whisper", "image": true}`, status: http.StatusOK, want: "hi", template: "spongemock"},
+	}
+	for _, tt := range tests {
+		if tt.method == "" {
+			tt.method = "POST"
+		}
+		r := httptest.NewRequest(tt.method, "/api/v1/mock", strings.NewReader(tt.body))
+		switch tt.contentType {
+		case "":
+			r.Header.Set("Content-Type", "application/json")
+		case "-":
+		default:
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		w := httptest.NewRecorder()
+		handleAPIMock(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: got content type %q", tt.name, ct)
+		}
+		if tt.status != http.StatusOK {
+			var res apiError
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || !strings.Contains(res.Error, tt.want) {
+				t.Errorf("%s: got error %s, want it to contain %q", tt.name, w.Body, tt.want)
+			}
+			continue
+		}
+		var res apiMockResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Errorf("%s: invalid response %s: %s", tt.name, w.Body, err)
+			continue
+		}
+		if res.Text != tt.want || res.Template != tt.template {
+			t.Errorf("%s: got text %q and template %q, want %q and %q", tt.name, res.Text, res.Template, tt.want, tt.template)
+		}
+		if (tt.template != "") != strings.Contains(res.ImageURL, memeRoute) {
+			t.Errorf("%s: got image url %q", tt.name, res.ImageURL)
+		}
+	}
+}
+
+func TestHandleAPIMockSeed(t *testing.T) {
+	defer setupAPITest(t)()
+	mockWith := func(body, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/v1/mock", strings.NewReader(body))
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		handleAPIMock(w, r)
+		return w
+	}
+
+	text := "the quick brown fox jumps over the lazy dog"
+	var first apiMockResponse
+	w := mockWith(`{"text": "`+text+`"}`, "")
+	if err := json.Unmarshal(w.Body.Bytes(), &first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Style != "spongebob" {
+		t.Errorf("default style is %s", first.Style)
+	}
+
+	// the reported seed gives the same text again, as plain text if asked
+	seed := strconv.FormatInt(first.Seed, 10)
+	w = mockWith(`{"text": "`+text+`", "seed": `+seed+`}`, "text/plain")
+	if got := w.Body.String(); got != first.Text {
+		t.Errorf("mocking with seed %s gave %q, want %q", seed, got, first.Text)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("got content type %q, want text/plain", ct)
+	}
+	if got := w.Header().Get("X-Spongemock-Seed"); got != seed {
+		t.Errorf("got seed header %q, want %s", got, seed)
+	}
+}
+
+func TestHandleAPIMockRateLimit(t *testing.T) {
+	defer setupAPITest(t)()
+	mockLimiter = newRateLimiter(mockRateLimit, 1)
+	var statuses []int
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest("POST", "/api/v1/mock", strings.NewReader(`{"text": "hi"}`))
+		w := httptest.NewRecorder()
+		handleAPIMock(w, r)
+		statuses = append(statuses, w.Code)
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Error("limited request has no Retry-After header")
+		}
+	}
+	if statuses[0] != http.StatusOK || statuses[1] != http.StatusTooManyRequests {
+		t.Errorf("got statuses %v, want %d then %d", statuses, http.StatusOK, http.StatusTooManyRequests)
+	}
+}
+
+func TestHandleAPILists(t *testing.T) {
+	defer setupAPITest(t)()
+	tests := []struct {
+		path    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{"/api/v1/styles", handleAPIStyles, `"name":"clap"`},
+		{"/api/v1/templates", handleAPITemplates, `"name":"thumbsup"`},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		tt.handler(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("GET %s = %d %s, want it to contain %s", tt.path, w.Code, w.Body, tt.want)
+		}
+		w = httptest.NewRecorder()
+		tt.handler(w, httptest.NewRequest("POST", tt.path, nil))
+		if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET" {
+			t.Errorf("POST %s = %d, want %d", tt.path, w.Code, http.StatusMethodNotAllowed)
+		}
+	}
+
+	memeTemplates = nil
+	w := httptest.NewRecorder()
+	handleAPITemplates(w, httptest.NewRequest("GET", "/api/v1/templates", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /api/v1/templates without templates = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestPrefersPlainText(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"text/plain", true},
+		{"application/json", false},
+		{"*/*", false},
+		{"text/plain, application/json", false},
+		{"text/plain, application/json;q=0.5", true},
+		{"text/plain;q=0.5, */*;q=0.1", true},
+		{"text/plain;q=0.5, */*", false},
+		{"text/plain;q=abc", false},
+		{"text/html", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/api/v1/mock", nil)
+		r.Header.Set("Accept", tt.accept)
+		if got := prefersPlainText(r); got != tt.want {
+			t.Errorf("prefersPlainText(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
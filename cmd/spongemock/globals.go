@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	_ "github.com/lib/pq"
+	"github.com/rjchee/spongemock/meme"
 )
 
 var (
@@ -20,15 +21,13 @@ var (
 	DEBUG   bool
 )
 
-const (
-	iconPath       = "static/icon.png"
-	memePath       = "static/spongemock.jpg"
-	groupThreshold = 0.8
-)
+const iconPath = "static/icon.png"
 
 type EnvVariable struct {
 	Name     string
 	Variable *string
+	// Optional variables may be left unset.
+	Optional bool
 }
 
 type WebPlugin interface {
@@ -37,17 +36,19 @@ type WebPlugin interface {
 	RegisterHandles(*http.ServeMux)
 }
 
-func init() {
+// setupGlobals reads the settings shared by every plugin. It's called from
+// main rather than init so that tests don't need a configured environment.
+func setupGlobals() {
 	SetEnvVariable("APP_URL", &AppURL)
 
 	u, err := url.Parse(AppURL)
 	if err != nil {
-		log.Fatal("invalid $APP_URL %s", AppURL)
+		log.Fatalf("invalid $APP_URL %s", AppURL)
 	}
 	icon, _ := url.Parse(iconPath)
 	IconURL = u.ResolveReference(icon).String()
-	meme, _ := url.Parse(memePath)
-	MemeURL = u.ResolveReference(meme).String()
+	memeImage, _ := url.Parse(meme.DefaultImagePath)
+	MemeURL = u.ResolveReference(memeImage).String()
 
 	dbURL := os.Getenv("DATABASE_URL")
 	if dbURL != "" {
@@ -69,19 +70,24 @@ func init() {
 }
 
 func createTable(name, schema string) error {
-	row := DB.QueryRow("SELECT EXISTS(SELECT * FROM information_schema.tables WHERE table_name=$1);", name)
-	var tableExists bool
-	err := row.Scan(&tableExists)
+	exists, err := tableExists(name)
 	if err != nil {
 		return err
 	}
-	if !tableExists {
+	if !exists {
 		_, err := DB.Exec("CREATE TABLE " + name + " " + schema + ";")
 		return err
 	}
 	return nil
 }
 
+func tableExists(name string) (bool, error) {
+	row := DB.QueryRow("SELECT EXISTS(SELECT * FROM information_schema.tables WHERE table_name=$1);", name)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
 func SetEnvVariable(name string, value *string) {
 	*value = os.Getenv(name)
 	if *value == "" {
@@ -90,5 +96,9 @@ func SetEnvVariable(name string, value *string) {
 }
 
 func (v EnvVariable) Set() {
+	if v.Optional {
+		*v.Variable = os.Getenv(v.Name)
+		return
+	}
 	SetEnvVariable(v.Name, v.Variable)
 }
@@ -12,6 +12,9 @@ var (
 
 	allPlugins = []WebPlugin{
 		NewSlackPlugin(),
+		NewAPIPlugin(),
+		NewWebPlugin(),
+		NewTwitterPlugin(),
 	}
 )
 
@@ -29,6 +32,13 @@ func (p mainPlugin) EnvVariables() []EnvVariable {
 func (p mainPlugin) RegisterHandles(m *http.ServeMux) {
 	fs := http.FileServer(http.Dir("static"))
 	m.Handle("/static/", http.StripPrefix("/static/", fs))
+
+	if err := setupMemes(); err != nil {
+		log.Printf("error setting up memes: %s\n", err)
+		log.Println("rendered memes will not be served")
+		return
+	}
+	m.HandleFunc(memeRoute, handleMeme)
 }
 
 func (p mainPlugin) Name() string {
@@ -36,6 +46,24 @@ func (p mainPlugin) Name() string {
 }
 
 func main() {
+	setupGlobals()
+	if len(os.Args) > 1 {
+		var err error
+		switch os.Args[1] {
+		case "rekey":
+			// re-encrypt stored credentials after changing the token keys
+			err = rekeyTokens()
+		case "migrate-slack-tokens":
+			err = migrateLegacySlackTokens()
+		default:
+			log.Fatalf("unknown command %s", os.Args[1])
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	plugins := []WebPlugin{mainPlugin{}}
 
 	whitelist := os.Getenv("PLUGINS")
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/rjchee/spongemock/meme"
+)
+
+const (
+	defaultMemeRetention  = 30 * 24 * time.Hour
+	memeCleanupInterval   = time.Hour
+	memeMemoryCacheSize   = 64
+	memeRoute             = "/meme/"
+	memeHashLength        = 32
+	memeRecordTableSchema = "(hash text PRIMARY KEY, text text NOT NULL, template text NOT NULL, created timestamptz NOT NULL DEFAULT now())"
+)
+
+var (
+	memeRetention = defaultMemeRetention
+	memeCacheDir  string
+	memeTemplates *meme.Catalog
+	memeRecords   memeRecordStore
+	memeCache     = newMemeMemoryCache(memeMemoryCacheSize)
+
+	memePathRegex = regexp.MustCompile("^" + memeRoute + "([0-9a-f]+)\\.png$")
+)
+
+// memeRecord is everything needed to render a meme again.
+type memeRecord struct {
+	Hash     string
+	Text     string
+	Template string
+	Created  time.Time
+}
+
+type memeRecordStore interface {
+	Put(memeRecord) error
+	// Get returns the record with the given hash, or nil if there is none.
+	Get(hash string) (*memeRecord, error)
+	// DeleteExpired deletes the records created before t and returns their
+	// hashes.
+	DeleteExpired(t time.Time) ([]string, error)
+}
+
+func memeHash(text, template string) string {
+	sum := sha256.Sum256([]byte(template + "\x00" + text))
+	return hex.EncodeToString(sum[:])[:memeHashLength]
+}
+
+// setupMemes prepares everything needed to serve rendered memes. Memes are
+// recorded in the database if there is one, and in memory otherwise.
+func setupMemes() error {
+	if v := os.Getenv("MEME_RETENTION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid $MEME_RETENTION %s: %s", v, err)
+		}
+		memeRetention = d
+	}
+
+	memeCacheDir = os.Getenv("MEME_CACHE_DIR")
+	if memeCacheDir == "" {
+		memeCacheDir = filepath.Join(os.TempDir(), "spongemock-memes")
+	}
+	if err := os.MkdirAll(memeCacheDir, 0755); err != nil {
+		return fmt.Errorf("error creating meme cache directory: %s", err)
+	}
+
+	c, err := meme.LoadDefaultCatalog()
+	if err != nil {
+		return err
+	}
+	memeTemplates = c
+
+	if DB != nil {
+		if err := createTable("meme_records", memeRecordTableSchema); err != nil {
+			return fmt.Errorf("error creating meme record table: %s", err)
+		}
+		memeRecords = dbMemeStore{}
+	} else {
+		memeRecords = newMemoryMemeStore()
+	}
+
+	go cleanupMemes()
+	return nil
+}
+
+// newMemeURL records a meme with the given text and returns the URL it will
+// be served at.
+func newMemeURL(text, template string) (string, error) {
+	if memeRecords == nil {
+		return "", errors.New("memes are not being served")
+	}
+	if _, ok := memeTemplates.Lookup(template); !ok {
+		return "", fmt.Errorf("unknown meme template %s", template)
+	}
+	rec := memeRecord{
+		Hash:     memeHash(text, template),
+		Text:     text,
+		Template: template,
+		Created:  time.Now(),
+	}
+	if err := memeRecords.Put(rec); err != nil {
+		return "", err
+	}
+	return AppURL + memeRoute + rec.Hash + meme.PNG.Extension(), nil
+}
+
+func handleMeme(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	m := memePathRegex.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	hash := m[1]
+
+	rec, err := memeRecords.Get(hash)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if rec == nil || time.Now().After(rec.Created.Add(memeRetention)) {
+		http.NotFound(w, r)
+		return
+	}
+	expires := rec.Created.Add(memeRetention)
+
+	data, err := renderMeme(rec)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// the image for a hash never changes, so it can be cached until the
+	// record expires
+	w.Header().Set("Content-Type", meme.PNG.ContentType())
+	w.Header().Set("ETag", `"`+hash+`"`)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(time.Until(expires).Seconds())))
+	w.Header().Set("Expires", expires.UTC().Format(http.TimeFormat))
+	http.ServeContent(w, r, hash+meme.PNG.Extension(), rec.Created, bytes.NewReader(data))
+}
+
+// renderMeme returns the encoded image for a record, checking the memory and
+// disk caches before rendering it.
+func renderMeme(rec *memeRecord) ([]byte, error) {
+	if data, ok := memeCache.Get(rec.Hash); ok {
+		return data, nil
+	}
+	path := filepath.Join(memeCacheDir, rec.Hash+meme.PNG.Extension())
+	if data, err := ioutil.ReadFile(path); err == nil {
+		memeCache.Add(rec.Hash, data)
+		return data, nil
+	}
+
+	t, ok := memeTemplates.Lookup(rec.Template)
+	if !ok {
+		return nil, fmt.Errorf("unknown meme template %s", rec.Template)
+	}
+	img, err := t.Renderer.Render(rec.Text)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering meme %s: %s", rec.Hash, err)
+	}
+	var buf bytes.Buffer
+	if err := meme.Encode(&buf, img, meme.PNG); err != nil {
+		return nil, fmt.Errorf("error encoding meme %s: %s", rec.Hash, err)
+	}
+	data := buf.Bytes()
+	memeCache.Add(rec.Hash, data)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		// the disk cache is only an optimization
+		log.Printf("error caching meme %s: %s\n", rec.Hash, err)
+	}
+	return data, nil
+}
+
+// cleanupMemes periodically deletes expired records and their cached images.
+func cleanupMemes() {
+	for {
+		hashes, err := memeRecords.DeleteExpired(time.Now().Add(-memeRetention))
+		if err != nil {
+			log.Println(err)
+		}
+		for _, hash := range hashes {
+			memeCache.Remove(hash)
+			path := filepath.Join(memeCacheDir, hash+meme.PNG.Extension())
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				log.Printf("error removing cached meme %s: %s\n", hash, err)
+			}
+		}
+		time.Sleep(memeCleanupInterval)
+	}
+}
+
+type dbMemeStore struct{}
+
+func (dbMemeStore) Put(rec memeRecord) error {
+	// recording the same meme again restarts its retention period
+	_, err := DB.Exec("INSERT INTO meme_records (hash, text, template, created) VALUES ($1, $2, $3, $4) ON CONFLICT (hash) DO UPDATE SET created=$4;", rec.Hash, rec.Text, rec.Template, rec.Created)
+	if err != nil {
+		return fmt.Errorf("error adding meme record to database: %s", err)
+	}
+	return nil
+}
+
+func (dbMemeStore) Get(hash string) (*memeRecord, error) {
+	row := DB.QueryRow("SELECT text, template, created FROM meme_records WHERE hash=$1;", hash)
+	rec := memeRecord{Hash: hash}
+	err := row.Scan(&rec.Text, &rec.Template, &rec.Created)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("error looking up meme record: %s", err)
+	default:
+		return &rec, nil
+	}
+}
+
+func (dbMemeStore) DeleteExpired(t time.Time) ([]string, error) {
+	rows, err := DB.Query("DELETE FROM meme_records WHERE created < $1 RETURNING hash;", t)
+	if err != nil {
+		return nil, fmt.Errorf("error deleting expired meme records: %s", err)
+	}
+	defer rows.Close()
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return hashes, fmt.Errorf("error reading expired meme records: %s", err)
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+type memoryMemeStore struct {
+	sync.Mutex
+	records map[string]memeRecord
+}
+
+func newMemoryMemeStore() *memoryMemeStore {
+	return &memoryMemeStore{records: make(map[string]memeRecord)}
+}
+
+func (s *memoryMemeStore) Put(rec memeRecord) error {
+	s.Lock()
+	defer s.Unlock()
+	s.records[rec.Hash] = rec
+	return nil
+}
+
+func (s *memoryMemeStore) Get(hash string) (*memeRecord, error) {
+	s.Lock()
+	defer s.Unlock()
+	rec, ok := s.records[hash]
+	if !ok {
+		return nil, nil
+	}
+	return &rec, nil
+}
+
+func (s *memoryMemeStore) DeleteExpired(t time.Time) ([]string, error) {
+	s.Lock()
+	defer s.Unlock()
+	var hashes []string
+	for hash, rec := range s.records {
+		if rec.Created.Before(t) {
+			delete(s.records, hash)
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes, nil
+}
+
+// memeMemoryCache keeps the most recently used encoded memes in memory.
+type memeMemoryCache struct {
+	sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memeCacheEntry struct {
+	hash string
+	data []byte
+}
+
+func newMemeMemoryCache(size int) *memeMemoryCache {
+	return &memeMemoryCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *memeMemoryCache) Get(hash string) ([]byte, bool) {
+	c.Lock()
+	defer c.Unlock()
+	e, ok := c.entries[hash]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(memeCacheEntry).data, true
+}
+
+func (c *memeMemoryCache) Add(hash string, data []byte) {
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.entries[hash]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[hash] = c.order.PushFront(memeCacheEntry{hash, data})
+	if c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.entries, last.Value.(memeCacheEntry).hash)
+	}
+}
+
+func (c *memeMemoryCache) Remove(hash string) {
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.entries[hash]; ok {
+		c.order.Remove(e)
+		delete(c.entries, hash)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// mockRateLimit and mockRateBurst limit how often one client can mock
+	// text through the API and the web page, since every mock with an
+	// image records a meme and may render it.
+	mockRateLimit = 20.0 / 60 // per second
+	mockRateBurst = 10
+)
+
+var mockLimiter = newRateLimiter(mockRateLimit, mockRateBurst)
+
+// rateLimiter is a token bucket for each client. A client's bucket fills up
+// at rate tokens a second up to burst tokens, and every request takes one.
+type rateLimiter struct {
+	sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*rateBucket
+	// lastSweep is when full buckets were last forgotten.
+	lastSweep time.Time
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*rateBucket),
+	}
+}
+
+// Allow takes a token from the client's bucket. If the bucket is empty, it
+// returns false along with how long until the next token.
+func (l *rateLimiter) Allow(client string, now time.Time) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	l.sweep(now)
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &rateBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep forgets the buckets which have filled up again, since a new bucket
+// is the same as a full one.
+func (l *rateLimiter) sweep(now time.Time) {
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	if now.Sub(l.lastSweep) < full {
+		return
+	}
+	l.lastSweep = now
+	for client, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// allowMock reports whether the client making r may mock more text. If it
+// may not, it sets the Retry-After header on w.
+func allowMock(w http.ResponseWriter, r *http.Request) bool {
+	ok, wait := mockLimiter.Allow(clientIP(r), time.Now())
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	}
+	return ok
+}
+
+// clientIP returns the address of the client making r. Heroku's router
+// appends the address it received the request from to X-Forwarded-For, so
+// only the last address can be trusted.
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		parts := strings.Split(fwd, ",")
+		return strings.TrimSpace(parts[len(parts)-1])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
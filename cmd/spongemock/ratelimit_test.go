@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(1, 2)
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("a", now); !ok {
+			t.Fatalf("request %d within the burst was limited", i)
+		}
+	}
+	ok, wait := l.Allow("a", now)
+	if ok {
+		t.Fatal("request past the burst was allowed")
+	}
+	if wait != time.Second {
+		t.Errorf("wait = %s, want 1s", wait)
+	}
+	if ok, _ := l.Allow("b", now); !ok {
+		t.Error("another client was limited")
+	}
+	if ok, _ := l.Allow("a", now.Add(time.Second)); !ok {
+		t.Error("request after the bucket refilled was limited")
+	}
+}
+
+func TestRateLimiterForgetsFullBuckets(t *testing.T) {
+	l := newRateLimiter(1, 2)
+	now := time.Now()
+	l.Allow("a", now)
+	l.Allow("b", now.Add(time.Second))
+	l.Allow("c", now.Add(2*time.Second))
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("full bucket was kept")
+	}
+	if _, ok := l.buckets["b"]; !ok {
+		t.Error("bucket which isn't full was forgotten")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	if got := clientIP(r); got != "10.0.0.1" {
+		t.Errorf("clientIP = %s, want 10.0.0.1", got)
+	}
+	// only the address added by the router can be trusted
+	r.Header.Set("X-Forwarded-For", "1.2.3.4, 5.6.7.8")
+	if got := clientIP(r); got != "5.6.7.8" {
+		t.Errorf("clientIP = %s, want 5.6.7.8", got)
+	}
+}
@@ -9,6 +9,7 @@ var (
 	slackClientID          string
 	slackClientSecret      string
 	slackVerificationToken string
+	slackSigningSecret     string
 )
 
 type slackPlugin struct{}
@@ -23,22 +24,43 @@ func (p slackPlugin) EnvVariables() []EnvVariable {
 			Name:     "SLACK_CLIENT_SECRET",
 			Variable: &slackClientSecret,
 		},
+		{
+			Name:     "SLACK_SIGNING_SECRET",
+			Variable: &slackSigningSecret,
+			Optional: true,
+		},
 		{
 			Name:     "SLACK_VERIFICATION_TOKEN",
 			Variable: &slackVerificationToken,
+			Optional: true,
 		},
 	}
 }
 
 func (p slackPlugin) RegisterHandles(m *http.ServeMux) {
+	if slackSigningSecret == "" && slackVerificationToken == "" {
+		log.Println("$SLACK_SIGNING_SECRET or $SLACK_VERIFICATION_TOKEN must be set!")
+		log.Println("slack integration could not be run")
+		return
+	}
+	if slackSigningSecret == "" {
+		log.Println("verifying slack requests with the deprecated verification token")
+	} else if slackVerificationToken != "" {
+		log.Println("verifying slack requests with either the signing secret or the verification token")
+	}
 	err := setupOAuthDB()
 	if err != nil {
 		log.Printf("error setting up OAuth DB: %s\n", err)
 		log.Println("slack integration could not be run")
 		return
 	}
-	m.HandleFunc("/slack", handleSlack)
-	m.HandleFunc("/slack/oauth2", handleSlackOAuth)
+	m.HandleFunc("/slack", verifySlackRequest(handleSlack))
+	m.HandleFunc("/slack/events", verifySlackRequest(handleSlackEvents))
+	m.HandleFunc("/slack/interactive", verifySlackRequest(handleSlackInteractive))
+	// the OAuth pages are visited by the user's browser rather than by
+	// Slack, so they aren't signed
+	m.HandleFunc(slackOAuthRoute, handleSlackOAuth)
+	m.HandleFunc(slackInstallRoute, handleSlackInstall)
 }
 
 func (p slackPlugin) Name() string {
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+const (
+	slackHistoryPageSize = 200
+	// slackHistoryMaxMessages and slackHistoryMaxAge bound how far back
+	// the history is searched for a message to mock.
+	slackHistoryMaxMessages = 1000
+	slackHistoryMaxAge      = 7 * 24 * time.Hour
+)
+
+// slackAPIError is an error code returned by a Slack Web API method.
+type slackAPIError struct {
+	Method string
+	Code   string
+}
+
+func (e slackAPIError) Error() string {
+	return fmt.Sprintf("%s error: %s", e.Method, e.Code)
+}
+
+// isInvalidSlackToken reports whether err means that the token used for a
+// request will never work again.
+func isInvalidSlackToken(err error) bool {
+	e, ok := err.(slackAPIError)
+	if !ok {
+		return false
+	}
+	switch e.Code {
+	case "token_revoked", "invalid_auth", "account_inactive", "not_authed":
+		return true
+	default:
+		return false
+	}
+}
+
+// isSlackNoAccess reports whether err means that the token used for a request
+// can't read the conversation, like when the bot isn't a member of it.
+func isSlackNoAccess(err error) bool {
+	e, ok := err.(slackAPIError)
+	return ok && (e.Code == "not_in_channel" || e.Code == "channel_not_found")
+}
+
+// callSlackAPI calls a Slack Web API method which the slack package doesn't
+// support and decodes the response into v. Error responses from Slack are
+// returned as a slackAPIError.
+func callSlackAPI(token, method string, values url.Values, v interface{}) error {
+	if values == nil {
+		values = url.Values{}
+	}
+	if token != "" {
+		values.Set("token", token)
+	}
+	resp, err := slack.HTTPClient.PostForm(slack.SLACK_API+method, values)
+	if err != nil {
+		return fmt.Errorf("%s request error: %s", method, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s HTTP status code: %d", method, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading %s response: %s", method, err)
+	}
+	var res slack.SlackResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		return fmt.Errorf("error parsing %s response: %s", method, err)
+	}
+	if !res.Ok {
+		return slackAPIError{method, res.Error}
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("error parsing %s response: %s", method, err)
+	}
+	return nil
+}
+
+type slackMessagesResponse struct {
+	Messages         []slack.Message `json:"messages"`
+	ResponseMetadata struct {
+		NextCursor string `json:"next_cursor"`
+	} `json:"response_metadata"`
+}
+
+// postSlackMessage posts a message like slack.Client.PostMessage does, but
+// can also broadcast a thread reply to the channel, which the slack package
+// doesn't support.
+func postSlackMessage(token, channel, text string, params slack.PostMessageParameters, broadcast bool) error {
+	method, values, err := slack.ApplyMsgOptions(token, channel,
+		slack.MsgOptionText(text, params.EscapeText),
+		slack.MsgOptionAttachments(params.Attachments...),
+		slack.MsgOptionPostMessageParameters(params),
+	)
+	if err != nil {
+		return err
+	}
+	if broadcast && params.ThreadTimestamp != "" {
+		values.Set("reply_broadcast", "true")
+	}
+	return callSlackAPI(token, method, values, nil)
+}
+
+// getSlackThreadParent returns the message which started the thread with the
+// given timestamp.
+func getSlackThreadParent(token, channel, threadTS string) (slack.Msg, error) {
+	var res slackMessagesResponse
+	err := callSlackAPI(token, "conversations.replies", url.Values{
+		"channel": {channel},
+		"ts":      {threadTS},
+		"limit":   {"1"},
+	}, &res)
+	if err != nil {
+		return slack.Msg{}, err
+	}
+	if len(res.Messages) == 0 {
+		return slack.Msg{}, fmt.Errorf("thread %s not found", threadTS)
+	}
+	return res.Messages[0].Msg, nil
+}
+
+// searchSlackHistory searches a conversation's history from the newest
+// message back, returning the first message which matches. It reads at most
+// max messages and none sent before oldest, and returns nil if no message
+// matches.
+func searchSlackHistory(token, channel string, oldest time.Time, max int, match func(slack.Message) bool) (*slack.Msg, error) {
+	values := url.Values{
+		"channel": {channel},
+		"limit":   {strconv.Itoa(slackHistoryPageSize)},
+		"oldest":  {strconv.FormatInt(oldest.Unix(), 10)},
+	}
+	read := 0
+	for read < max {
+		var res slackMessagesResponse
+		if err := callSlackAPI(token, "conversations.history", values, &res); err != nil {
+			return nil, err
+		}
+		for _, msg := range res.Messages {
+			if read == max {
+				break
+			}
+			read++
+			if match(msg) {
+				return &msg.Msg, nil
+			}
+		}
+		if res.ResponseMetadata.NextCursor == "" {
+			break
+		}
+		values.Set("cursor", res.ResponseMetadata.NextCursor)
+	}
+	return nil, nil
+}
+
+// respondSlack sends a message to a response_url, which Slack gives out with
+// commands and interactions so that apps can respond after acknowledging
+// them.
+func respondSlack(responseURL string, res interface{}) error {
+	body, err := json.Marshal(res)
+	if err != nil {
+		return fmt.Errorf("error marshalling slack response: %s", err)
+	}
+	resp, err := slack.HTTPClient.Post(responseURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("slack response error: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack response HTTP status code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// The slack package predates Block Kit, so these are the parts of it used
+// by the app.
+
+// Block Kit rejects blocks with longer text than these, counted in
+// characters.
+const (
+	slackMaxSectionText = 3000
+	slackMaxAltText     = 2000
+)
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func plainText(s string) *slackText {
+	return &slackText{"plain_text", s}
+}
+
+type slackBlock struct {
+	Type     string         `json:"type"`
+	BlockID  string         `json:"block_id,omitempty"`
+	Text     *slackText     `json:"text,omitempty"`
+	ImageURL string         `json:"image_url,omitempty"`
+	AltText  string         `json:"alt_text,omitempty"`
+	Elements []slackElement `json:"elements,omitempty"`
+}
+
+type slackElement struct {
+	Type          string        `json:"type"`
+	ActionID      string        `json:"action_id"`
+	Text          *slackText    `json:"text,omitempty"`
+	Value         string        `json:"value,omitempty"`
+	Style         string        `json:"style,omitempty"`
+	Placeholder   *slackText    `json:"placeholder,omitempty"`
+	Options       []slackOption `json:"options,omitempty"`
+	InitialOption *slackOption  `json:"initial_option,omitempty"`
+}
+
+type slackOption struct {
+	Text  *slackText `json:"text"`
+	Value string     `json:"value"`
+}
+
+// slackAction is a block element the user interacted with.
+type slackAction struct {
+	ActionID       string      `json:"action_id"`
+	BlockID        string      `json:"block_id"`
+	Value          string      `json:"value"`
+	SelectedOption slackOption `json:"selected_option"`
+}
+
+// truncateSlackText shortens s to at most max characters, ending it with an
+// ellipsis if anything was cut. A link or mention which would be cut in half
+// is dropped.
+func truncateSlackText(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	runes := []rune(s)
+	res := string(runes[:max-1])
+	if i := strings.LastIndexByte(res, '<'); i > strings.LastIndexByte(res, '>') {
+		res = res[:i]
+	}
+	return res + "…"
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/rjchee/spongemock/mock"
+)
+
+// slackEventTTL is how long event IDs are remembered for. Slack stops
+// retrying an event well before then.
+const slackEventTTL = time.Hour
+
+var (
+	slackMentionRegex = regexp.MustCompile("^(\\s*<@\\w+(\\|[^>]*)?>)+\\s*")
+	slackEventIDs     = newSlackEventDeduper(slackEventTTL)
+)
+
+// slackEventEnvelope is the outer object of every Events API request.
+type slackEventEnvelope struct {
+	Type      string          `json:"type"`
+	Challenge string          `json:"challenge"`
+	TeamID    string          `json:"team_id"`
+	EventID   string          `json:"event_id"`
+	Event     json.RawMessage `json:"event"`
+}
+
+type slackEvent struct {
+	Type     string `json:"type"`
+	User     string `json:"user"`
+	BotID    string `json:"bot_id"`
+	Text     string `json:"text"`
+	Channel  string `json:"channel"`
+	TS       string `json:"ts"`
+	ThreadTS string `json:"thread_ts"`
+	// Tokens lists the users whose tokens were revoked in a tokens_revoked
+	// event.
+	Tokens struct {
+		OAuth []string `json:"oauth"`
+		Bot   []string `json:"bot"`
+	} `json:"tokens"`
+}
+
+func handleSlackEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var env slackEventEnvelope
+	if err := json.NewDecoder(r.Body).Decode(&env); err != nil {
+		log.Printf("invalid slack event: %s\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	switch env.Type {
+	case "url_verification":
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(env.Challenge))
+	case "event_callback":
+		// Slack retries events which aren't acknowledged within three
+		// seconds, so acknowledge them before doing any work
+		w.WriteHeader(http.StatusOK)
+		if slackEventIDs.Seen(env.EventID, time.Now()) {
+			log.Printf("ignoring duplicate slack event %s\n", env.EventID)
+			return
+		}
+		var ev slackEvent
+		if err := json.Unmarshal(env.Event, &ev); err != nil {
+			log.Printf("invalid slack event %s: %s\n", env.EventID, err)
+			return
+		}
+		go func() {
+			if err := handleSlackEvent(env.TeamID, ev); err != nil {
+				log.Printf("error handling slack event %s: %s\n", env.EventID, err)
+			}
+		}()
+	default:
+		log.Printf("unknown slack event type %s\n", env.Type)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func handleSlackEvent(teamID string, ev slackEvent) error {
+	if DEBUG {
+		log.Printf("slack event from team %s: %+v\n", teamID, ev)
+	}
+	switch ev.Type {
+	case "app_mention":
+		return handleSlackMention(teamID, ev)
+	case "app_uninstalled":
+		return deleteSlackTeam(teamID)
+	case "tokens_revoked":
+		if len(ev.Tokens.Bot) > 0 {
+			// the app can't do anything without its bot token
+			return deleteSlackTeam(teamID)
+		}
+		for _, userID := range ev.Tokens.OAuth {
+			if err := deleteSlackUserToken(teamID, userID); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return nil
+	}
+}
+
+// handleSlackMention mocks the parent message when the app is mentioned in a
+// thread, and the rest of the mentioning message otherwise.
+func handleSlackMention(teamID string, ev slackEvent) error {
+	if ev.BotID != "" {
+		return nil
+	}
+	tokens, err := lookupSlackTokens(teamID, ev.User)
+	if err != nil {
+		return err
+	} else if tokens.Bot == "" {
+		return fmt.Errorf("the app is not installed in team %s", teamID)
+	}
+
+	text := slackMentionRegex.ReplaceAllString(ev.Text, "")
+	opts, text, err := parseSlackOptions(text)
+	if err != nil {
+		return err
+	}
+
+	mocker := slackMocker.WithStyle(opts.style)
+	var mockedText string
+	threadTS := ev.ThreadTS
+	if threadTS != "" && threadTS != ev.TS {
+		parent, err := getSlackThreadParent(tokens.History(), ev.Channel, threadTS)
+		if err != nil {
+			return forgetInvalidSlackToken(err, teamID, ev.User, tokens, tokens.History())
+		}
+		mockedText = mocker.MockSeed(parent.Text, mock.Seed(ev.Channel+":"+parent.Timestamp))
+	} else {
+		// reply in a thread so that mentions don't clutter the channel
+		threadTS = ev.TS
+		mockedText = mocker.Mock(text)
+	}
+	if mockedText == "" {
+		return errors.New("no message to mock")
+	}
+
+	params := newSlackMockParams(mockedText, opts.template)
+	params.ThreadTimestamp = threadTS
+	if DEBUG {
+		log.Printf("message: %+v\n", params)
+		return nil
+	}
+	err = postSlackMessage(tokens.Bot, ev.Channel, fmt.Sprintf("<@%s>", ev.User), params, opts.broadcast)
+	return forgetInvalidSlackToken(err, teamID, ev.User, tokens, tokens.Bot)
+}
+
+// slackEventDeduper remembers recently seen event IDs so that events Slack
+// delivers more than once are only handled once.
+type slackEventDeduper struct {
+	sync.Mutex
+	ttl  time.Duration
+	seen map[string]time.Time
+}
+
+func newSlackEventDeduper(ttl time.Duration) *slackEventDeduper {
+	return &slackEventDeduper{
+		ttl:  ttl,
+		seen: make(map[string]time.Time),
+	}
+}
+
+// Seen records the event ID and reports whether it was already seen.
+func (d *slackEventDeduper) Seen(id string, now time.Time) bool {
+	d.Lock()
+	defer d.Unlock()
+	for k, t := range d.seen {
+		if now.Sub(t) > d.ttl {
+			delete(d.seen, k)
+		}
+	}
+	if _, ok := d.seen[id]; ok {
+		return true
+	}
+	d.seen[id] = now
+	return false
+}
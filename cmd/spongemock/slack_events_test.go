@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlackEventDeduper(t *testing.T) {
+	d := newSlackEventDeduper(time.Minute)
+	now := time.Now()
+	tests := []struct {
+		id   string
+		at   time.Duration
+		seen bool
+	}{
+		{"Ev1", 0, false},
+		{"Ev1", time.Second, true},
+		{"Ev2", time.Second, false},
+		{"Ev1", time.Minute, true},
+		{"Ev2", time.Minute, true},
+		// the first sighting is remembered for the TTL, not the last
+		{"Ev1", time.Minute + time.Second, false},
+		{"Ev1", time.Minute + 2*time.Second, true},
+		{"Ev2", 2 * time.Minute, false},
+	}
+	for _, tt := range tests {
+		if got := d.Seen(tt.id, now.Add(tt.at)); got != tt.seen {
+			t.Errorf("Seen(%s) after %s = %v, want %v", tt.id, tt.at, got, tt.seen)
+		}
+	}
+	d.Seen("Ev3", now.Add(time.Hour))
+	if len(d.seen) != 1 {
+		t.Errorf("deduper remembers %d events after they expired, want 1", len(d.seen))
+	}
+}
+
+func TestHandleSlackEvents(t *testing.T) {
+	old := slackEventIDs
+	slackEventIDs = newSlackEventDeduper(slackEventTTL)
+	defer func() {
+		slackEventIDs = old
+	}()
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+		want   string
+	}{
+		{name: "wrong method", method: "GET", status: http.StatusMethodNotAllowed},
+		{name: "invalid json", body: `{"type": `, status: http.StatusBadRequest},
+		{name: "url verification", body: `{"type": "url_verification", "challenge": "abc123"}`, status: http.StatusOK, want: "abc123"},
+		{name: "event", body: `{"type": "event_callback", "team_id": "T1", "event_id": "Ev1", "event": {"type": "reaction_added"}}`, status: http.StatusOK},
+		{name: "retried event", body: `{"type": "event_callback", "team_id": "T1", "event_id": "Ev1", "event": {"type": "reaction_added"}}`, status: http.StatusOK},
+		{name: "unknown type", body: `{"type": "something_new"}`, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		if tt.method == "" {
+			tt.method = "POST"
+		}
+		w := httptest.NewRecorder()
+		handleSlackEvents(w, httptest.NewRequest(tt.method, "/slack/events", strings.NewReader(tt.body)))
+		if w.Code != tt.status || w.Body.String() != tt.want {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, w.Code, w.Body, tt.status, tt.want)
+		}
+	}
+	if !slackEventIDs.Seen("Ev1", time.Now()) || len(slackEventIDs.seen) != 1 {
+		t.Errorf("handled events weren't recorded once: %v", slackEventIDs.seen)
+	}
+}
+
+func TestSlackMentionRegex(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"<@U123> hello", "hello"},
+		{"  <@U123|spongemock>  hello", "hello"},
+		{"<@U123> <@U456> style:clap hi", "style:clap hi"},
+		{"hi <@U123>", "hi <@U123>"},
+		{"<@U123>", ""},
+	}
+	for _, tt := range tests {
+		if got := slackMentionRegex.ReplaceAllString(tt.in, ""); got != tt.want {
+			t.Errorf("stripping mentions from %q = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/nlopes/slack"
+	"github.com/rjchee/spongemock/mock"
+)
+
+// slackMockMessageCallback is the callback ID of the "Mock this message"
+// message shortcut.
+const slackMockMessageCallback = "mock_message"
+
+type slackID struct {
+	ID string `json:"id"`
+}
+
+// slackInteraction is the payload of a request to the interactivity
+// endpoint.
+type slackInteraction struct {
+	Type        string    `json:"type"`
+	CallbackID  string    `json:"callback_id"`
+	ResponseURL string    `json:"response_url"`
+	Team        slackID   `json:"team"`
+	User        slackID   `json:"user"`
+	Channel     slackID   `json:"channel"`
+	Message     slack.Msg `json:"message"`
+	// Actions are the block elements used in a block_actions interaction.
+	Actions []slackAction `json:"actions"`
+}
+
+func handleSlackInteractive(w http.ResponseWriter, r *http.Request) {
+	if !isValidSlackRequest(r) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var in slackInteraction
+	if err := json.Unmarshal([]byte(r.PostFormValue("payload")), &in); err != nil {
+		log.Printf("invalid slack interaction payload: %s\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if DEBUG {
+		log.Printf("slack interaction: %+v\n", in)
+	}
+
+	switch {
+	case in.Type == "message_action" && in.CallbackID == slackMockMessageCallback:
+		// acknowledge the shortcut right away and respond through the
+		// response_url once the message is mocked
+		w.WriteHeader(http.StatusOK)
+		go func() {
+			if err := handleSlackMockMessage(in); err != nil {
+				log.Printf("error mocking slack message: %s\n", err)
+			}
+		}()
+	case in.Type == "block_actions" && len(in.Actions) > 0:
+		w.WriteHeader(http.StatusOK)
+		go func() {
+			if err := handleSlackPreviewAction(in, in.Actions[0]); err != nil {
+				log.Printf("error handling slack preview action: %s\n", err)
+			}
+		}()
+	default:
+		log.Printf("unknown slack interaction %s %s\n", in.Type, in.CallbackID)
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// handleSlackMockMessage mocks the message a "Mock this message" shortcut
+// was used on.
+func handleSlackMockMessage(in slackInteraction) error {
+	var response slackSlashResponse
+	defer func() {
+		if response.empty() {
+			return
+		}
+		if DEBUG {
+			log.Printf("response: %+v\n", response)
+		} else if err := respondSlack(in.ResponseURL, response); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	userID := in.User.ID
+	tokens, err := lookupSlackTokens(in.Team.ID, userID)
+	if err != nil {
+		return err
+	} else if tokens.Bot == "" {
+		setNoOAuthResponse(&response, in.Team.ID, userID)
+		return nil
+	}
+
+	msg := in.Message
+	if msg.Text == "" {
+		response.ResponseType = ephemeral
+		response.Text = "There's no text in that message to mock."
+		return errors.New("no message to mock")
+	}
+	opts, _, err := parseSlackOptions("")
+	if err != nil {
+		return err
+	}
+	channel := in.Channel.ID
+	mockedText := slackMocker.WithStyle(opts.style).MockSeed(msg.Text, mock.Seed(channel+":"+msg.Timestamp))
+
+	params := newSlackMockParams(mockedText, opts.template)
+	// keep the mock with the message if it's in a thread
+	params.ThreadTimestamp = msg.ThreadTimestamp
+	if DEBUG {
+		log.Printf("message: %+v\n", params)
+		return nil
+	}
+	text := fmt.Sprintf("<@%s>", userID)
+	if msg.User != "" && msg.User != userID {
+		text = fmt.Sprintf("<@%s> mocked <@%s>", userID, msg.User)
+	}
+	err = postSlackMessage(tokens.Bot, channel, text, params, false)
+	if isInvalidSlackToken(err) {
+		if err := deleteSlackToken(in.Team.ID, userID, tokens, tokens.Bot); err != nil {
+			return err
+		}
+		setNoOAuthResponse(&response, in.Team.ID, userID)
+	} else if err != nil {
+		response.ResponseType = ephemeral
+		response.Text = "Sorry, I couldn't post in this conversation."
+	}
+	return err
+}
@@ -1,93 +1,379 @@
 package main
 
 import (
+	"bytes"
+	"crypto/hmac"
 	"database/sql"
 	"errors"
 	"fmt"
+	"html/template"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	slackOAuthRoute   = "/slack/oauth2"
+	slackInstallRoute = "/slack/install"
+	slackOAuthCookie  = "slack_oauth_nonce"
+)
+
+var (
+	// slackBotScopes are the scopes of the workspace bot token, which is used
+	// for everything the app does.
+	slackBotScopes = []string{
+		"app_mentions:read",
+		"channels:history",
+		"chat:write",
+		"chat:write.customize",
+		"chat:write.public",
+		"commands",
+		"groups:history",
+		"im:history",
+		"mpim:history",
+	}
+	// slackUserScopes are the scopes of the optional user tokens, which let
+	// users mock messages in conversations the bot isn't a member of.
+	slackUserScopes = []string{
+		"channels:history",
+		"groups:history",
+		"im:history",
+		"mpim:history",
+	}
 
-	"github.com/nlopes/slack"
+	slackOAuthPage = template.Must(template.New("oauth").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Spongemock for Slack</title>
+<link rel="icon" href="/static/icon.png">
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 0 auto; padding: 1em; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1><img src="/static/icon.png" alt="" width="48" height="48"> Spongemock for Slack</h1>
+{{- if .Error}}
+<p class="error">{{.Error}}</p>
+<p><a href="/slack/install">Try adding Spongemock again</a>, or run <code>/spongemock</code> in Slack to get a new link.</p>
+{{- else}}
+{{- if .UserOnly}}
+<p>Spongemock can now mock messages in conversations you're in, even ones it hasn't been added to.</p>
+{{- else}}
+<p>Spongemock was added to {{if .TeamName}}{{.TeamName}}{{else}}your workspace{{end}}!
+Type <code>/spongemock help</code> in Slack to get started.</p>
+{{- end}}
+{{- if .AppID}}
+<p><a href="https://slack.com/app_redirect?app={{.AppID}}&amp;team={{.TeamID}}">Go back to Slack</a></p>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
 )
 
+// slackTokens are the tokens used to handle a request from a user.
+type slackTokens struct {
+	Bot string
+	// User is empty unless the user authorized the app themselves.
+	User string
+}
+
+// History returns the token to read conversation history with, which is
+// the user's token if they have one.
+func (t slackTokens) History() string {
+	if t.User != "" {
+		return t.User
+	}
+	return t.Bot
+}
+
+type slackOAuthResponse struct {
+	AppID       string `json:"app_id"`
+	AccessToken string `json:"access_token"`
+	BotUserID   string `json:"bot_user_id"`
+	Team        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"team"`
+	AuthedUser struct {
+		ID          string `json:"id"`
+		AccessToken string `json:"access_token"`
+	} `json:"authed_user"`
+}
+
 func setupOAuthDB() error {
 	if DB == nil {
 		return errors.New("database required to store OAuth tokens")
 	}
-	err := createTable("slack_oauth", "(user_id text PRIMARY KEY, token text NOT NULL)")
+	if err := setupTokenKeys(); err != nil {
+		return err
+	}
+	err := createTable("slack_teams", "(team_id text PRIMARY KEY, bot_user_id text NOT NULL, bot_token text NOT NULL, key_id text NOT NULL DEFAULT '')")
 	if err != nil {
-		return fmt.Errorf("error creating oauth table: %s", err)
+		return fmt.Errorf("error creating slack team table: %s", err)
 	}
-	return nil
+	err = createTable("slack_user_tokens", "(team_id text NOT NULL, user_id text NOT NULL, token text NOT NULL, key_id text NOT NULL DEFAULT '', PRIMARY KEY (team_id, user_id))")
+	if err != nil {
+		return fmt.Errorf("error creating slack user token table: %s", err)
+	}
+	if err := warnLegacySlackTokens(); err != nil {
+		return err
+	}
+	return addTokenKeyColumns()
+}
+
+// getPublicOAuthLink returns a link for the given user to install the app in
+// their workspace.
+func getPublicOAuthLink(teamID, userID string) string {
+	state, _, err := newSlackOAuthState(teamID, userID, time.Now())
+	if err != nil {
+		// the install page can make its own state
+		log.Println(err)
+		return AppURL + slackInstallRoute
+	}
+	return slackAuthorizeURL(state, teamID, slackBotScopes)
+}
+
+// getUserOAuthLink returns a link for the given user to let the app read
+// history as them, in a workspace it's already installed in.
+func getUserOAuthLink(teamID, userID string) (string, error) {
+	state, _, err := newSlackOAuthState(teamID, userID, time.Now())
+	if err != nil {
+		return "", err
+	}
+	return slackAuthorizeURL(state, teamID, nil), nil
 }
 
-func getPublicOAuthLink() string {
-	return fmt.Sprintf("https://slack.com/oauth/authorize?&client_id=%s&scope=commands,channels:history,chat:write:bot,groups:history,im:history,mpim:history", slackClientID)
+// slackAuthorizeURL returns the URL to authorize the app with. The bot scopes
+// are left out when only a user token is wanted.
+func slackAuthorizeURL(state, teamID string, botScopes []string) string {
+	v := url.Values{
+		"client_id":    {slackClientID},
+		"user_scope":   {strings.Join(slackUserScopes, ",")},
+		"redirect_uri": {AppURL + slackOAuthRoute},
+		"state":        {state},
+	}
+	if len(botScopes) > 0 {
+		v.Set("scope", strings.Join(botScopes, ","))
+	}
+	if teamID != "" {
+		v.Set("team", teamID)
+	}
+	return "https://slack.com/oauth/v2/authorize?" + v.Encode()
+}
+
+// handleSlackInstall starts installing the app from outside of Slack. The
+// state is tied to the browser with a cookie, since there's no Slack user
+// to tie it to.
+func handleSlackInstall(w http.ResponseWriter, r *http.Request) {
+	state, nonce, err := newSlackOAuthState("", "", time.Now())
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     slackOAuthCookie,
+		Value:    nonce,
+		Path:     slackOAuthRoute,
+		MaxAge:   int(slackOAuthStateTTL.Seconds()),
+		Secure:   strings.HasPrefix(AppURL, "https://"),
+		HttpOnly: true,
+	})
+	http.Redirect(w, r, slackAuthorizeURL(state, "", slackBotScopes), http.StatusFound)
 }
 
 func handleSlackOAuth(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
 		log.Printf("invalid form data: %s\n", err)
-		w.WriteHeader(http.StatusBadRequest)
+		writeSlackOAuthPage(w, http.StatusBadRequest, slackOAuthPageData{Error: "Something went wrong."})
 		return
 	}
 	if denial := r.FormValue("error"); denial != "" {
 		// don't handle user permission denials
-		w.WriteHeader(http.StatusOK)
+		writeSlackOAuthPage(w, http.StatusOK, slackOAuthPageData{Error: "Spongemock wasn't added to your workspace."})
 		return
 	}
+
+	st, err := parseSlackOAuthState(r.FormValue("state"), time.Now())
+	if err != nil {
+		log.Println(err)
+		writeSlackOAuthPage(w, http.StatusBadRequest, slackOAuthPageData{Error: "This link is invalid or has expired."})
+		return
+	}
+	if st.TeamID == "" {
+		c, err := r.Cookie(slackOAuthCookie)
+		if err != nil || !hmac.Equal([]byte(c.Value), []byte(st.Nonce)) {
+			log.Println("oauth state does not match the browser's nonce")
+			writeSlackOAuthPage(w, http.StatusBadRequest, slackOAuthPageData{Error: "This link is invalid or has expired."})
+			return
+		}
+	}
+
 	code := r.FormValue("code")
 	if code == "" {
 		log.Println("no oauth code given")
-		w.WriteHeader(http.StatusBadRequest)
+		writeSlackOAuthPage(w, http.StatusBadRequest, slackOAuthPageData{Error: "Something went wrong."})
 		return
 	}
-	oAuthResponse, err := slack.GetOAuthResponse(slackClientID, slackClientSecret, code, AppURL+"/slack/oauth2", false)
+	var res slackOAuthResponse
+	err = callSlackAPI("", "oauth.v2.access", url.Values{
+		"client_id":     {slackClientID},
+		"client_secret": {slackClientSecret},
+		"code":          {code},
+		"redirect_uri":  {AppURL + slackOAuthRoute},
+	}, &res)
 	if err != nil {
 		log.Printf("error occurred when sending an oauth response: %s", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeSlackOAuthPage(w, http.StatusInternalServerError, slackOAuthPageData{Error: "Slack couldn't finish adding Spongemock."})
 		return
 	}
 
-	err = storeSlackOAuthToken(oAuthResponse.UserID, oAuthResponse.AccessToken)
+	if (st.TeamID != "" && st.TeamID != res.Team.ID) || (st.UserID != "" && st.UserID != res.AuthedUser.ID) {
+		log.Printf("oauth state for %s/%s was used by %s/%s\n", st.TeamID, st.UserID, res.Team.ID, res.AuthedUser.ID)
+		writeSlackOAuthPage(w, http.StatusBadRequest, slackOAuthPageData{Error: "This link was made for someone else."})
+		return
+	}
 
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	if err := storeSlackOAuthResponse(res); err != nil {
+		log.Println(err)
+		writeSlackOAuthPage(w, http.StatusInternalServerError, slackOAuthPageData{Error: "Something went wrong."})
 		return
 	}
 
-	// go back to slack after authenticating
-	http.Redirect(w, r, "https://my.slack.com", http.StatusFound)
+	writeSlackOAuthPage(w, http.StatusOK, slackOAuthPageData{
+		TeamID:   res.Team.ID,
+		TeamName: res.Team.Name,
+		AppID:    res.AppID,
+		UserOnly: res.AccessToken == "",
+	})
+}
+
+type slackOAuthPageData struct {
+	TeamID   string
+	TeamName string
+	AppID    string
+	// UserOnly is set when a user authorized the app to read history as
+	// them, rather than installing it.
+	UserOnly bool
+	// Error is shown instead of the success message if it is set.
+	Error string
 }
 
-func storeSlackOAuthToken(userID, token string) error {
-	_, err := DB.Exec("INSERT INTO slack_oauth (user_id, token) VALUES ($1, $2) ON CONFLICT (user_id) DO UPDATE SET token=$2;", userID, token)
+func writeSlackOAuthPage(w http.ResponseWriter, status int, data slackOAuthPageData) {
+	var buf bytes.Buffer
+	if err := slackOAuthPage.Execute(&buf, data); err != nil {
+		log.Printf("error rendering oauth page: %s\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// storeSlackOAuthResponse stores the bot token from an installation, along
+// with the authorizing user's token if they granted any user scopes. Users
+// authorizing the app after it's installed only grant user scopes.
+func storeSlackOAuthResponse(res slackOAuthResponse) error {
+	if res.Team.ID == "" || (res.AccessToken == "" && res.AuthedUser.AccessToken == "") {
+		return errors.New("oauth response has no tokens")
+	}
+	if res.AccessToken != "" {
+		keyID, sealed, err := encryptToken(res.AccessToken, tokenAD("slack_teams", res.Team.ID))
+		if err != nil {
+			return err
+		}
+		_, err = DB.Exec("INSERT INTO slack_teams (team_id, bot_user_id, bot_token, key_id) VALUES ($1, $2, $3, $4) ON CONFLICT (team_id) DO UPDATE SET bot_user_id=$2, bot_token=$3, key_id=$4;", res.Team.ID, res.BotUserID, sealed, keyID)
+		if err != nil {
+			return fmt.Errorf("error adding bot token to database: %s", err)
+		}
+	}
+	if res.AuthedUser.AccessToken == "" {
+		return nil
+	}
+	keyID, sealed, err := encryptToken(res.AuthedUser.AccessToken, tokenAD("slack_user_tokens", res.Team.ID, res.AuthedUser.ID))
 	if err != nil {
-		return fmt.Errorf("error adding oauth token to database: %s", err)
+		return err
+	}
+	_, err = DB.Exec("INSERT INTO slack_user_tokens (team_id, user_id, token, key_id) VALUES ($1, $2, $3, $4) ON CONFLICT (team_id, user_id) DO UPDATE SET token=$3, key_id=$4;", res.Team.ID, res.AuthedUser.ID, sealed, keyID)
+	if err != nil {
+		return fmt.Errorf("error adding user token to database: %s", err)
 	}
 	return nil
 }
 
-func lookupSlackOAuthToken(userID string) (string, error) {
-	row := DB.QueryRow("SELECT token FROM slack_oauth WHERE user_id=$1;", userID)
-	var token string
-	err := row.Scan(&token)
+// lookupSlackTokens returns the tokens for a user in a workspace. The bot
+// token is empty if the app isn't installed in the workspace.
+func lookupSlackTokens(teamID, userID string) (slackTokens, error) {
+	var t slackTokens
+	var keyID, sealed string
+	row := DB.QueryRow("SELECT key_id, bot_token FROM slack_teams WHERE team_id=$1;", teamID)
+	err := row.Scan(&keyID, &sealed)
 	switch {
 	case err == sql.ErrNoRows:
-		// return empty string and no error if the user is not in the database
-		return "", nil
+		// return empty tokens and no error if the app isn't installed
+		return t, nil
 	case err != nil:
-		return "", fmt.Errorf("error looking up oauth token: %s", err)
-	default:
-		return token, nil
+		return t, fmt.Errorf("error looking up bot token: %s", err)
+	}
+	if t.Bot, err = decryptToken(keyID, sealed, tokenAD("slack_teams", teamID)); err != nil {
+		return t, err
+	}
+
+	row = DB.QueryRow("SELECT key_id, token FROM slack_user_tokens WHERE team_id=$1 AND user_id=$2;", teamID, userID)
+	err = row.Scan(&keyID, &sealed)
+	switch {
+	case err == sql.ErrNoRows:
+		return t, nil
+	case err != nil:
+		return t, fmt.Errorf("error looking up user token: %s", err)
+	}
+	t.User, err = decryptToken(keyID, sealed, tokenAD("slack_user_tokens", teamID, userID))
+	return t, err
+}
+
+// deleteSlackToken deletes a token which Slack reported as revoked. Deleting
+// the bot token uninstalls the app from the workspace.
+func deleteSlackToken(teamID, userID string, tokens slackTokens, token string) error {
+	if token != tokens.Bot {
+		return deleteSlackUserToken(teamID, userID)
 	}
+	return deleteSlackTeam(teamID)
 }
 
-func deleteSlackOAuthToken(userID string) error {
-	_, err := DB.Exec("DELETE FROM slack_oauth WHERE user_id=$1;", userID)
+// forgetInvalidSlackToken deletes token if err means it's no longer valid,
+// and returns err.
+func forgetInvalidSlackToken(err error, teamID, userID string, tokens slackTokens, token string) error {
+	if isInvalidSlackToken(err) {
+		if derr := deleteSlackToken(teamID, userID, tokens, token); derr != nil {
+			log.Println(derr)
+		}
+	}
+	return err
+}
+
+func deleteSlackUserToken(teamID, userID string) error {
+	_, err := DB.Exec("DELETE FROM slack_user_tokens WHERE team_id=$1 AND user_id=$2;", teamID, userID)
 	if err != nil {
-		return fmt.Errorf("error deleting oauth token: %s", err)
+		return fmt.Errorf("error deleting user token: %s", err)
+	}
+	return nil
+}
+
+// deleteSlackTeam deletes everything stored for a workspace.
+func deleteSlackTeam(teamID string) error {
+	if _, err := DB.Exec("DELETE FROM slack_user_tokens WHERE team_id=$1;", teamID); err != nil {
+		return fmt.Errorf("error deleting user tokens: %s", err)
+	}
+	if _, err := DB.Exec("DELETE FROM slack_teams WHERE team_id=$1;", teamID); err != nil {
+		return fmt.Errorf("error deleting bot token: %s", err)
 	}
 	return nil
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// slackOAuthStateTTL is how long an OAuth link can be used for after it is
+// shown.
+const slackOAuthStateTTL = time.Hour
+
+// slackOAuthState is the state passed through the OAuth flow to make sure
+// the callback completes an install which this app started. States from a
+// slash command are tied to the workspace and user who ran it, and states
+// from the install page are tied to the browser with a nonce cookie.
+type slackOAuthState struct {
+	TeamID  string `json:"t,omitempty"`
+	UserID  string `json:"u,omitempty"`
+	Nonce   string `json:"n"`
+	Expires int64  `json:"e"`
+}
+
+// newSlackOAuthState returns a signed state for the given workspace and user,
+// which may be empty, along with its nonce.
+func newSlackOAuthState(teamID, userID string, now time.Time) (string, string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("error generating oauth state nonce: %s", err)
+	}
+	st := slackOAuthState{
+		TeamID:  teamID,
+		UserID:  userID,
+		Nonce:   hex.EncodeToString(b),
+		Expires: now.Add(slackOAuthStateTTL).Unix(),
+	}
+	payload, err := json.Marshal(st)
+	if err != nil {
+		return "", "", fmt.Errorf("error marshalling oauth state: %s", err)
+	}
+	enc := base64.RawURLEncoding.EncodeToString(payload)
+	return enc + "." + base64.RawURLEncoding.EncodeToString(signSlackOAuthState(enc)), st.Nonce, nil
+}
+
+// parseSlackOAuthState checks the signature and expiry of a state.
+func parseSlackOAuthState(s string, now time.Time) (slackOAuthState, error) {
+	var st slackOAuthState
+	parts := strings.Split(s, ".")
+	if len(parts) != 2 {
+		return st, errors.New("malformed oauth state")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, signSlackOAuthState(parts[0])) {
+		return st, errors.New("invalid oauth state signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return st, errors.New("malformed oauth state")
+	}
+	if err := json.Unmarshal(payload, &st); err != nil {
+		return st, fmt.Errorf("malformed oauth state: %s", err)
+	}
+	if now.After(time.Unix(st.Expires, 0)) {
+		return st, errors.New("oauth state expired")
+	}
+	return st, nil
+}
+
+func signSlackOAuthState(payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(slackClientSecret))
+	mac.Write([]byte("oauth-state:" + payload))
+	return mac.Sum(nil)
+}
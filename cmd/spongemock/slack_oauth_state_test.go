@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setSlackClientSecret sets the secret states are signed with, and returns a
+// function restoring the old one.
+func setSlackClientSecret(secret string) func() {
+	old := slackClientSecret
+	slackClientSecret = secret
+	return func() {
+		slackClientSecret = old
+	}
+}
+
+func TestSlackOAuthStateRoundTrip(t *testing.T) {
+	defer setSlackClientSecret("client-secret")()
+	now := time.Now()
+	s, nonce, err := newSlackOAuthState("T123", "U456", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := parseSlackOAuthState(s, now.Add(slackOAuthStateTTL-time.Second))
+	if err != nil {
+		t.Fatalf("parseSlackOAuthState failed: %s", err)
+	}
+	if st.TeamID != "T123" || st.UserID != "U456" || st.Nonce != nonce {
+		t.Errorf("parseSlackOAuthState = %+v, want team T123, user U456 and nonce %s", st, nonce)
+	}
+
+	other, otherNonce, err := newSlackOAuthState("", "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == s || otherNonce == nonce {
+		t.Error("states share a nonce")
+	}
+}
+
+func TestSlackOAuthStateRejected(t *testing.T) {
+	defer setSlackClientSecret("client-secret")()
+	now := time.Now()
+	s, _, err := newSlackOAuthState("T123", "U456", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(s, ".")
+	// a payload for another workspace, signed with the original signature
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"t":"T999","u":"U456","n":"00","e":` + "9999999999" + `}`))
+
+	tests := []struct {
+		name  string
+		state string
+		now   time.Time
+	}{
+		{"expired", s, now.Add(slackOAuthStateTTL + time.Second)},
+		{"forged payload", forged + "." + parts[1], now},
+		{"missing signature", parts[0], now},
+		{"bad signature encoding", parts[0] + ".!!", now},
+		{"extra part", s + ".x", now},
+		{"empty", "", now},
+	}
+	for _, tt := range tests {
+		if _, err := parseSlackOAuthState(tt.state, tt.now); err == nil {
+			t.Errorf("%s: parseSlackOAuthState succeeded", tt.name)
+		}
+	}
+
+	// states are signed with the client secret
+	slackClientSecret = "another-secret"
+	if _, err := parseSlackOAuthState(s, now); err == nil {
+		t.Error("state signed with another secret was accepted")
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	mathrand "math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rjchee/spongemock/meme"
+	"github.com/rjchee/spongemock/mock"
+)
+
+// slackPreviewTTL is how long the buttons on a preview keep working.
+const slackPreviewTTL = time.Hour
+
+// action IDs of the preview's buttons
+const (
+	slackPreviewSend    = "preview_send"
+	slackPreviewShuffle = "preview_shuffle"
+	slackPreviewStyle   = "preview_style"
+	slackPreviewCancel  = "preview_cancel"
+)
+
+var slackPreviews = newSlackPreviewStore(slackPreviewTTL)
+
+// slackPreview is a mock shown only to the user who asked for it, which is
+// posted to the conversation once they decide to send it.
+type slackPreview struct {
+	TeamID  string
+	UserID  string
+	Channel string
+	// Text is the text being mocked, which was sent by MockedUser if it
+	// came from the conversation.
+	Text       string
+	MockedUser string
+	Style      *mock.Style
+	Template   *meme.Template
+	Seed       int64
+}
+
+func (p *slackPreview) mockedText() string {
+	return slackMocker.WithStyle(p.Style).MockSeed(p.Text, p.Seed)
+}
+
+// shuffle picks a new seed so that the text is mocked differently.
+func (p *slackPreview) shuffle() {
+	p.Seed = mathrand.Int63()
+}
+
+// messageText is the text of the message the mock is posted with.
+func (p *slackPreview) messageText() string {
+	if p.MockedUser == "" || p.MockedUser == p.UserID {
+		return fmt.Sprintf("<@%s>", p.UserID)
+	}
+	return fmt.Sprintf("<@%s>: /spongemock <@%s>", p.UserID, p.MockedUser)
+}
+
+// response returns the ephemeral message showing the preview with the given
+// ID.
+func (p *slackPreview) response(id string) slackSlashResponse {
+	mockedText := p.mockedText()
+	altText := truncateSlackText(slackDisplayText(mockedText), slackMaxAltText)
+	if altText == "" {
+		altText = slackUsername + " mocking meme"
+	}
+	var styleOptions []slackOption
+	var current *slackOption
+	for _, style := range mock.Styles() {
+		o := slackOption{plainText(style.Name), style.Name}
+		styleOptions = append(styleOptions, o)
+		if style == p.Style {
+			current = &o
+		}
+	}
+	return slackSlashResponse{
+		ResponseType:    ephemeral,
+		ReplaceOriginal: true,
+		Text:            mockedText,
+		Blocks: []slackBlock{
+			{Type: "section", Text: &slackText{"mrkdwn", truncateSlackText(mockedText, slackMaxSectionText)}},
+			{Type: "image", ImageURL: slackMemeURL(mockedText, p.Template), AltText: altText},
+			{Type: "actions", BlockID: id, Elements: []slackElement{
+				{Type: "button", ActionID: slackPreviewSend, Text: plainText("Send"), Style: "primary"},
+				{Type: "button", ActionID: slackPreviewShuffle, Text: plainText("Shuffle")},
+				{Type: "static_select", ActionID: slackPreviewStyle, Placeholder: plainText("Change style"), Options: styleOptions, InitialOption: current},
+				{Type: "button", ActionID: slackPreviewCancel, Text: plainText("Cancel")},
+			}},
+		},
+	}
+}
+
+// handleSlackPreviewAction handles the user pressing a button on a preview,
+// responding through the response_url.
+func handleSlackPreviewAction(in slackInteraction, action slackAction) error {
+	response := slackSlashResponse{ResponseType: ephemeral, ReplaceOriginal: true}
+	defer func() {
+		if DEBUG {
+			log.Printf("response: %+v\n", response)
+		} else if err := respondSlack(in.ResponseURL, response); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	id := action.BlockID
+	p, ok := slackPreviews.Get(id)
+	if !ok || p.UserID != in.User.ID {
+		response.Text = "This preview has expired. Please run `/spongemock` again."
+		return nil
+	}
+
+	switch action.ActionID {
+	case slackPreviewSend:
+		tokens, err := lookupSlackTokens(p.TeamID, p.UserID)
+		if err != nil {
+			response.Text = "Sorry, something went wrong."
+			return err
+		} else if tokens.Bot == "" {
+			setNoOAuthResponse(&response, p.TeamID, p.UserID)
+			return nil
+		}
+		params := newSlackMockParams(p.mockedText(), p.Template)
+		if DEBUG {
+			log.Printf("message: %+v\n", params)
+		} else if err := postSlackMessage(tokens.Bot, p.Channel, p.messageText(), params, false); err != nil {
+			if isSlackNoAccess(err) {
+				log.Println(err)
+				response.Text = "I can't post in this conversation. Invite me with `/invite @Spongemock` and try again."
+			} else if handleSlackTokenError(err, &response, p.TeamID, p.UserID, tokens, tokens.Bot) != http.StatusOK {
+				response.Text = "Sorry, I couldn't post in this conversation."
+			}
+			return nil
+		}
+		slackPreviews.Delete(id)
+		response = slackSlashResponse{DeleteOriginal: true}
+	case slackPreviewShuffle:
+		p.shuffle()
+		slackPreviews.Put(id, p)
+		response = p.response(id)
+	case slackPreviewStyle:
+		style, ok := mock.LookupStyle(action.SelectedOption.Value)
+		if !ok {
+			response = p.response(id)
+			return fmt.Errorf("unknown style %s", action.SelectedOption.Value)
+		}
+		p.Style = style
+		slackPreviews.Put(id, p)
+		response = p.response(id)
+	case slackPreviewCancel:
+		slackPreviews.Delete(id)
+		response = slackSlashResponse{DeleteOriginal: true}
+	default:
+		response = p.response(id)
+		return fmt.Errorf("unknown preview action %s", action.ActionID)
+	}
+	return nil
+}
+
+// slackPreviewStore keeps previews until they're sent, cancelled or expire.
+type slackPreviewStore struct {
+	sync.Mutex
+	ttl      time.Duration
+	previews map[string]slackPreviewEntry
+}
+
+type slackPreviewEntry struct {
+	preview slackPreview
+	expires time.Time
+}
+
+func newSlackPreviewStore(ttl time.Duration) *slackPreviewStore {
+	return &slackPreviewStore{
+		ttl:      ttl,
+		previews: make(map[string]slackPreviewEntry),
+	}
+}
+
+// Add stores a new preview and returns its ID.
+func (s *slackPreviewStore) Add(p *slackPreview) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating preview id: %s", err)
+	}
+	id := hex.EncodeToString(b)
+	s.Put(id, p)
+	return id, nil
+}
+
+// Put stores a preview, restarting its expiry.
+func (s *slackPreviewStore) Put(id string, p *slackPreview) {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	for k, e := range s.previews {
+		if now.After(e.expires) {
+			delete(s.previews, k)
+		}
+	}
+	s.previews[id] = slackPreviewEntry{*p, now.Add(s.ttl)}
+}
+
+// Get returns a copy of the preview with the given ID.
+func (s *slackPreviewStore) Get(id string) (*slackPreview, bool) {
+	s.Lock()
+	defer s.Unlock()
+	e, ok := s.previews[id]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return &e.preview, true
+}
+
+func (s *slackPreviewStore) Delete(id string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.previews, id)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/rjchee/spongemock/mock"
+)
+
+func TestTruncateSlackText(t *testing.T) {
+	tests := []struct {
+		in   string
+		max  int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"this is too long", 10, "this is t…"},
+		{"ünïcödé text", 5, "ünïc…"},
+		{"hi <@U123ABC|bob>", 10, "hi …"},
+		{"<@U1|a> and more text", 10, "<@U1|a> a…"},
+	}
+	for _, tt := range tests {
+		if got := truncateSlackText(tt.in, tt.max); got != tt.want {
+			t.Errorf("truncateSlackText(%q, %d) = %q, want %q", tt.in, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestSlackPreviewFitsBlockLimits(t *testing.T) {
+	p := &slackPreview{
+		UserID: "U1",
+		Text:   strings.Repeat("mock me <@U123ABC|bob> ", 500),
+		Style:  mock.Spongebob,
+	}
+	res := p.response("preview")
+	if res.Text == "" {
+		t.Error("preview has no fallback text")
+	}
+	for _, b := range res.Blocks {
+		if b.Text != nil && utf8.RuneCountInString(b.Text.Text) > slackMaxSectionText {
+			t.Errorf("%s block text has %d characters", b.Type, utf8.RuneCountInString(b.Text.Text))
+		}
+		if n := utf8.RuneCountInString(b.AltText); n > slackMaxAltText {
+			t.Errorf("%s block alt text has %d characters", b.Type, n)
+		}
+	}
+}
+
+func TestSlackPreviewAltTextIsNeverEmpty(t *testing.T) {
+	p := &slackPreview{UserID: "U1", Text: ":smile:", Style: mock.Spongebob}
+	for _, b := range p.response("preview").Blocks {
+		if b.Type == "image" && b.AltText == "" {
+			t.Error("image block has no alt text")
+		}
+	}
+}
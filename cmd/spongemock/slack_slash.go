@@ -1,56 +1,34 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
-	"math/rand"
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/nlopes/slack"
+	"github.com/rjchee/spongemock/meme"
+	"github.com/rjchee/spongemock/mock"
 )
 
 const (
 	slackUsername = "Spongebob"
 	slackFallback = "*Spongebob mocking meme*"
+	// slackThreadHelp explains how to mock in a thread, since slash commands
+	// can't.
+	slackThreadHelp = "To mock in a thread, mention `@Spongemock` in the thread, optionally with `broadcast` to send the reply to the channel too, or use \"Mock this message\" on a reply"
 )
 
 var (
-	slackTextRegex = regexp.MustCompile("&amp;|&lt;|&gt;|<.+?>|\\s+|.?")
-	slackUserRegex = regexp.MustCompile("^<@(U\\w+)\\|.+?>$")
+	slackUserRegex   = regexp.MustCompile("^<@(U\\w+)\\|.+?>$")
+	slackOptionRegex = regexp.MustCompile("^(?:(style|template):(\\S+)|(broadcast)(?:\\s|$))\\s*")
+	slackMocker      = mock.New(mock.SlackTokenizer)
 )
 
-func transformSlackText(m string) string {
-	var buffer bytes.Buffer
-	letters := slackTextRegex.FindAllString(m, -1)
-	trFuncs := []func(string) string{
-		strings.ToUpper,
-		strings.ToLower,
-	}
-	idx := rand.Intn(2)
-	groupSize := rand.Intn(2) + 1
-	for _, ch := range letters {
-		// ignore html escaped entities
-		if len(ch) == 1 && strings.TrimSpace(ch) != "" {
-			ch = trFuncs[idx](ch)
-			groupSize--
-			if groupSize == 0 {
-				idx = (idx + 1) % 2
-				groupSize = 1
-				if rand.Float64() > groupThreshold {
-					groupSize++
-				}
-			}
-		}
-		buffer.WriteString(ch)
-	}
-	return buffer.String()
-}
-
 type slackResponseType string
 
 const (
@@ -60,7 +38,16 @@ const (
 
 type slackSlashResponse struct {
 	ResponseType slackResponseType `json:"response_type,omitempty"`
-	Text         string            `json:"text"`
+	Text         string            `json:"text,omitempty"`
+	Blocks       []slackBlock      `json:"blocks,omitempty"`
+	// ReplaceOriginal and DeleteOriginal change the message a response_url
+	// came from.
+	ReplaceOriginal bool `json:"replace_original,omitempty"`
+	DeleteOriginal  bool `json:"delete_original,omitempty"`
+}
+
+func (r slackSlashResponse) empty() bool {
+	return r.Text == "" && len(r.Blocks) == 0 && !r.DeleteOriginal
 }
 
 func isValidSlackRequest(r *http.Request) bool {
@@ -73,59 +60,190 @@ func isValidSlackRequest(r *http.Request) bool {
 		log.Printf("invalid form data: %s\n", err)
 		return false
 	}
-	if tk := r.PostFormValue("token"); tk != slackVerificationToken {
-		log.Printf("received invalid token %s\n", tk)
-		return false
-	}
 	return true
 }
 
-func getLastSlackMessage(api *slack.Client, c string, u string) (string, string, error) {
-	histParams := slack.NewHistoryParameters()
-	var h *slack.History
-	var err error
-	if c[0] == 'C' {
-		h, err = api.GetChannelHistory(c, histParams)
-	} else if c[0] == 'G' {
-		// handle private channels
-		h, err = api.GetGroupHistory(c, histParams)
-	} else if c[0] == 'D' {
-		// handle direct messages
-		h, err = api.GetIMHistory(c, histParams)
-	} else {
-		err = fmt.Errorf("unknown channel type, channel_id = %s", c)
-	}
-	if err != nil {
-		return "", "", err
-	}
-
-	for _, msg := range h.Messages {
+// getLastSlackMessage returns the last message in the conversation. If u is
+// given, it returns the last message sent by that user.
+func getLastSlackMessage(token, c, u string) (slack.Msg, error) {
+	msg, err := searchSlackHistory(token, c, time.Now().Add(-slackHistoryMaxAge), slackHistoryMaxMessages, func(msg slack.Message) bool {
 		// don't support message subtypes for now
 		if msg.SubType != "" || msg.Text == "" {
-			continue
+			return false
 		}
 		// if a user is supplied, search for the last message by a user
-		if u != "" && msg.User != u {
-			continue
-		}
-		return msg.Text, msg.User, nil
+		return u == "" || msg.User == u
+	})
+	if err != nil {
+		return slack.Msg{}, err
+	}
+	if msg != nil {
+		return *msg, nil
 	}
 
 	err = errors.New("no last message found")
 	log.Println(err)
-	return "", "", err
+	return slack.Msg{}, err
+}
+
+// slackOptions are the options which can be given at the start of a
+// /spongemock command or a mention.
+type slackOptions struct {
+	style    *mock.Style
+	template *meme.Template
+	// broadcast sends replies in a thread to the channel as well. It's only
+	// allowed in mentions, since slash commands don't say which thread they
+	// were used in.
+	broadcast bool
+}
+
+// parseSlackOptions removes leading style:<name>, template:<name> and
+// broadcast options from the text of a command or mention. Without a template option, the
+// default template is used, and without a style option, the template's style
+// is used.
+func parseSlackOptions(text string) (slackOptions, string, error) {
+	var opts slackOptions
+	for {
+		m := slackOptionRegex.FindStringSubmatch(text)
+		if m == nil {
+			break
+		}
+		switch {
+		case m[3] == "broadcast":
+			opts.broadcast = true
+		case m[1] == "style":
+			style, ok := mock.LookupStyle(m[2])
+			if !ok {
+				return opts, "", fmt.Errorf("unknown style `%s`\n%s", m[2], slackStyleList())
+			}
+			opts.style = style
+		case m[1] == "template":
+			if memeTemplates == nil {
+				return opts, "", errors.New("meme templates are not available right now")
+			}
+			t, ok := memeTemplates.Lookup(m[2])
+			if !ok {
+				return opts, "", fmt.Errorf("unknown template `%s`\n%s", m[2], slackTemplateList())
+			}
+			opts.template = t
+		}
+		text = text[len(m[0]):]
+	}
+
+	if opts.template == nil && memeTemplates != nil {
+		opts.template = memeTemplates.Default()
+	}
+	if opts.style == nil && opts.template != nil {
+		opts.style, _ = mock.LookupStyle(opts.template.Style)
+	}
+	if opts.style == nil {
+		opts.style = mock.Spongebob
+	}
+	return opts, text, nil
 }
 
-func setNoOAuthResponse(r *slackSlashResponse) {
+func slackTemplateList() string {
+	if memeTemplates == nil {
+		return "Meme templates are not available right now."
+	}
+	lines := []string{"Available templates:"}
+	for _, t := range memeTemplates.Templates() {
+		lines = append(lines, fmt.Sprintf("`%s`: %s", t.Name, t.Description))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func slackStyleList() string {
+	lines := []string{"Available styles:"}
+	for _, style := range mock.Styles() {
+		lines = append(lines, fmt.Sprintf("`%s`: %s", style.Name, style.Description))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// slackMemeURL returns the URL of the meme for mocked mrkdwn, falling back to
+// the meme without text if it can't be rendered.
+func slackMemeURL(mockedText string, template *meme.Template) string {
+	caption := slackDisplayText(mockedText)
+	if template == nil || caption == "" {
+		return MemeURL
+	}
+	u, err := newMemeURL(caption, template.Name)
+	if err != nil {
+		log.Println(err)
+		return MemeURL
+	}
+	return u
+}
+
+// newSlackMockParams returns the parameters for posting mocked text along
+// with its meme.
+func newSlackMockParams(mockedText string, template *meme.Template) slack.PostMessageParameters {
+	params := slack.NewPostMessageParameters()
+	params.Username = slackUsername
+	params.Attachments = []slack.Attachment{{
+		Text:     mockedText,
+		Fallback: slackFallback,
+		ImageURL: slackMemeURL(mockedText, template),
+	}}
+	params.EscapeText = false
+	params.IconURL = IconURL
+	return params
+}
+
+func setNoOAuthResponse(r *slackSlashResponse, teamID, userID string) {
 	r.ResponseType = ephemeral
-	r.Text = "Looks like you haven't added Spongemock as an app on Slack yet! Please click <" + getPublicOAuthLink() + "|here> to give me the permissions to post in your channels."
+	r.Text = "Looks like Spongemock hasn't been added to this workspace yet! Please click <" + getPublicOAuthLink(teamID, userID) + "|here> to give me the permissions to post in your channels."
+}
+
+// setNoAccessResponse tells the user how to let the app read a conversation
+// it can't read with token.
+func setNoAccessResponse(r *slackSlashResponse, teamID, userID string, tokens slackTokens, token string) {
+	r.ResponseType = ephemeral
+	if token != tokens.Bot {
+		r.Text = "I can't read the messages here, even as you. Try inviting me with `/invite @Spongemock`, or give me some text to mock."
+		return
+	}
+	r.Text = "I can't read the messages here. Invite me with `/invite @Spongemock`, or give me some text to mock."
+	link, err := getUserOAuthLink(teamID, userID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	r.Text += " To mock messages where I can't be invited, like direct messages, <" + link + "|let me read them as you>."
+}
+
+// handleSlackTokenError handles an error from a Slack API call made with
+// token. If the token can't read the conversation, the user is told how to
+// let it. If the token is no longer valid, it's deleted and the user is told
+// how to fix it. It returns the HTTP status to respond with.
+func handleSlackTokenError(err error, r *slackSlashResponse, teamID, userID string, tokens slackTokens, token string) int {
+	log.Println(err)
+	if isSlackNoAccess(err) {
+		setNoAccessResponse(r, teamID, userID, tokens, token)
+		return http.StatusOK
+	}
+	if !isInvalidSlackToken(err) {
+		return http.StatusInternalServerError
+	}
+	if err := deleteSlackToken(teamID, userID, tokens, token); err != nil {
+		log.Println(err)
+		return http.StatusInternalServerError
+	}
+	if token == tokens.Bot {
+		setNoOAuthResponse(r, teamID, userID)
+	} else {
+		r.ResponseType = ephemeral
+		r.Text = "Looks like your own permissions for Spongemock were revoked, so I've forgotten them. Please try again."
+	}
+	return http.StatusOK
 }
 
 func handleSlack(w http.ResponseWriter, r *http.Request) {
 	status := http.StatusOK
 	response := slackSlashResponse{}
 	defer func() {
-		if response != (slackSlashResponse{}) {
+		if !response.empty() {
 			output, err := json.Marshal(response)
 			if err != nil {
 				status = http.StatusInternalServerError
@@ -161,101 +279,98 @@ func handleSlack(w http.ResponseWriter, r *http.Request) {
 			"`/spongemock` will mock the last message in the channel",
 			"`/spongemock @user` will mock the last message from that user",
 			"`/spongemock text` will mock the given text",
+			"`/spongemock style:<style> ...` will mock using a different style",
+			"`/spongemock template:<template> ...` will mock using a different meme",
+			slackThreadHelp,
+			"Mocks are previewed before they're sent, so you can shuffle or restyle them first",
+			"`/spongemock styles` will list the available styles",
+			"`/spongemock templates` will list the available meme templates",
 		}, "\n")
 		return
 	}
 
-	// oauth is required for subsequent commands
+	if reqText == "styles" {
+		response.ResponseType = ephemeral
+		response.Text = slackStyleList()
+		return
+	}
+
+	if reqText == "templates" {
+		response.ResponseType = ephemeral
+		response.Text = slackTemplateList()
+		return
+	}
+
+	opts, reqText, err := parseSlackOptions(reqText)
+	if err != nil {
+		response.ResponseType = ephemeral
+		response.Text = err.Error()
+		return
+	}
+	if opts.broadcast {
+		// Slack doesn't say which thread a slash command was used in, so
+		// the mock could only ever go to the channel
+		response.ResponseType = ephemeral
+		response.Text = "`/spongemock` can't reply in threads, so `broadcast` only works in mentions.\n" + slackThreadHelp
+		return
+	}
+
+	// the app must be installed in the workspace for subsequent commands
+	teamID := r.PostFormValue("team_id")
 	userID := r.PostFormValue("user_id")
-	authToken, err := lookupSlackOAuthToken(userID)
+	tokens, err := lookupSlackTokens(teamID, userID)
 	if err != nil {
 		status = http.StatusInternalServerError
 		log.Println(err)
 		return
-	} else if authToken == "" {
-		setNoOAuthResponse(&response)
+	} else if tokens.Bot == "" {
+		setNoOAuthResponse(&response, teamID, userID)
 		return
 	}
-	api := slack.New(authToken)
-
 	channel := r.PostFormValue("channel_id")
-	var message string
-	var mockedUser string
-	if reqText == "" {
-		message, mockedUser, err = getLastSlackMessage(api, channel, "")
-		if err != nil {
-			if err.Error() == "token_revoked" {
-				err = deleteSlackOAuthToken(userID)
-				if err != nil {
-					status = http.StatusInternalServerError
-					log.Println(err)
-					return
-				}
-				setNoOAuthResponse(&response)
-			} else {
-				status = http.StatusInternalServerError
-			}
-			return
+	var lastMsg slack.Msg
+	if reqText == "" || slackUserRegex.MatchString(reqText) {
+		var u string
+		if m := slackUserRegex.FindStringSubmatch(reqText); m != nil {
+			u = m[1]
 		}
-	} else if slackUserRegex.MatchString(reqText) {
-		message, mockedUser, err = getLastSlackMessage(api, channel, slackUserRegex.FindStringSubmatch(reqText)[1])
+		lastMsg, err = getLastSlackMessage(tokens.History(), channel, u)
 		if err != nil {
-			if err.Error() == "token_revoked" {
-				err = deleteSlackOAuthToken(userID)
-				if err != nil {
-					status = http.StatusInternalServerError
-					log.Println(err)
-					return
-				}
-				setNoOAuthResponse(&response)
-			} else {
-				status = http.StatusInternalServerError
-			}
+			status = handleSlackTokenError(err, &response, teamID, userID, tokens, tokens.History())
 			return
 		}
-	} else {
-		message = reqText
 	}
 
-	mockedText := transformSlackText(message)
-	if mockedText == "" {
+	p := &slackPreview{
+		TeamID:   teamID,
+		UserID:   userID,
+		Channel:  channel,
+		Style:    opts.style,
+		Template: opts.template,
+	}
+	if lastMsg.Timestamp != "" {
+		// mock messages from the history the same way every time
+		p.Text = lastMsg.Text
+		p.MockedUser = lastMsg.User
+		p.Seed = mock.Seed(channel + ":" + lastMsg.Timestamp)
+	} else {
+		p.Text = reqText
+		p.shuffle()
+	}
+	if p.mockedText() == "" {
 		status = http.StatusInternalServerError
 		log.Println("no message to mock")
 		return
 	}
 
-	params := slack.NewPostMessageParameters()
-	params.Username = slackUsername
-	params.Attachments = []slack.Attachment{{
-		Text:     mockedText,
-		Fallback: slackFallback,
-		ImageURL: MemeURL,
-	}}
-	params.EscapeText = false
-	params.IconURL = IconURL
-	if DEBUG {
-		log.Printf("message: %+v\n", params)
-	} else {
-		var text string
-		if mockedUser == "" || mockedUser == userID {
-			text = fmt.Sprintf("<@%s>", userID)
-		} else {
-			text = fmt.Sprintf("<@%s>: /spongemock <@%s>", userID, mockedUser)
-		}
-		_, _, err = api.PostMessage(channel, text, params)
-		if err != nil {
-			if err.Error() == "token_revoked" {
-				err = deleteSlackOAuthToken(userID)
-				if err != nil {
-					status = http.StatusInternalServerError
-					log.Println(err)
-					return
-				}
-				setNoOAuthResponse(&response)
-			} else {
-				status = http.StatusInternalServerError
-			}
-			return
-		}
+	// show the mock to the user before posting it
+	id, err := slackPreviews.Add(p)
+	if err != nil {
+		status = http.StatusInternalServerError
+		log.Println(err)
+		return
 	}
+	response = p.response(id)
+	// the preview is the command's response rather than a replacement
+	response.ReplaceOriginal = false
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSlackOptions(t *testing.T) {
+	tests := []struct {
+		in        string
+		style     string
+		broadcast bool
+		text      string
+	}{
+		{"hello", "spongebob", false, "hello"},
+		{"style:clap hello", "clap", false, "hello"},
+		{"broadcast style:whisper hi", "whisper", true, "hi"},
+		{"broadcast", "spongebob", true, ""},
+		{"broadcasting news", "spongebob", false, "broadcasting news"},
+		{"hello style:clap", "spongebob", false, "hello style:clap"},
+	}
+	for _, tt := range tests {
+		opts, text, err := parseSlackOptions(tt.in)
+		if err != nil {
+			t.Errorf("parseSlackOptions(%q) failed: %s", tt.in, err)
+			continue
+		}
+		if opts.style.Name != tt.style || opts.broadcast != tt.broadcast || text != tt.text {
+			t.Errorf("parseSlackOptions(%q) = %s, %v, %q, want %s, %v, %q", tt.in, opts.style.Name, opts.broadcast, text, tt.style, tt.broadcast, tt.text)
+		}
+	}
+	if _, _, err := parseSlackOptions("style:shouting hi"); err == nil {
+		t.Error("parseSlackOptions accepted an unknown style")
+	}
+}
+
+func TestHandleSlackRejectsBroadcast(t *testing.T) {
+	form := url.Values{"command": {"/spongemock"}, "text": {"broadcast hello"}, "team_id": {"T1"}, "user_id": {"U1"}}
+	r := httptest.NewRequest("POST", "/slack", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handleSlack(w, r)
+
+	var res slackSlashResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("invalid response %s: %s", w.Body, err)
+	}
+	if w.Code != http.StatusOK || res.ResponseType != ephemeral || !strings.Contains(res.Text, "@Spongemock") {
+		t.Errorf("got %d %+v, want an ephemeral reply pointing to mentions", w.Code, res)
+	}
+}
+
+func TestHandleSlackTokenErrorWithoutAccess(t *testing.T) {
+	defer setSlackClientSecret("client-secret")()
+	tokens := slackTokens{Bot: "xoxb-bot"}
+	for _, code := range []string{"not_in_channel", "channel_not_found"} {
+		var r slackSlashResponse
+		status := handleSlackTokenError(slackAPIError{"conversations.history", code}, &r, "T1", "U1", tokens, tokens.Bot)
+		if status != http.StatusOK || r.ResponseType != ephemeral {
+			t.Errorf("%s: got status %d and response %+v, want an ephemeral reply", code, status, r)
+		}
+		if !strings.Contains(r.Text, "/invite @Spongemock") || !strings.Contains(r.Text, "https://slack.com/oauth/v2/authorize?") {
+			t.Errorf("%s: response %q doesn't offer an invite and a link", code, r.Text)
+		}
+	}
+
+	tokens.User = "xoxp-user"
+	var r slackSlashResponse
+	handleSlackTokenError(slackAPIError{"conversations.history", "channel_not_found"}, &r, "T1", "U1", tokens, tokens.User)
+	if strings.Contains(r.Text, "oauth") {
+		t.Errorf("user with a token was offered a link again: %q", r.Text)
+	}
+
+	r = slackSlashResponse{}
+	if status := handleSlackTokenError(slackAPIError{"conversations.history", "ratelimited"}, &r, "T1", "U1", tokens, tokens.Bot); status != http.StatusInternalServerError {
+		t.Errorf("other errors got status %d, want %d", status, http.StatusInternalServerError)
+	}
+}
+
+func TestUserOAuthLinkOnlyAsksForUserScopes(t *testing.T) {
+	defer setSlackClientSecret("client-secret")()
+	link, err := getUserOAuthLink("T1", "U1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("scope") != "" || q.Get("user_scope") == "" || q.Get("team") != "T1" {
+		t.Errorf("user link %s should only ask for user scopes in team T1", link)
+	}
+	st, err := parseSlackOAuthState(q.Get("state"), time.Now())
+	if err != nil || st.TeamID != "T1" || st.UserID != "U1" {
+		t.Errorf("user link state = %+v, %v, want it tied to T1/U1", st, err)
+	}
+
+	u, _ = url.Parse(getPublicOAuthLink("T1", "U1"))
+	if u.Query().Get("scope") == "" {
+		t.Error("install link doesn't ask for bot scopes")
+	}
+}
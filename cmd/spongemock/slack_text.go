@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	// slackMarkupRegex matches the parts of mrkdwn which aren't shown as
+	// they're written: code, <...> links and mentions, and emoji shortcodes.
+	slackMarkupRegex = regexp.MustCompile("```([\\s\\S]*?)```|`([^`\\n]+)`|<([^>\\n]+)>|:[a-z0-9_+'-]+:")
+	slackSpaceRegex  = regexp.MustCompile("[ \\t]{2,}")
+)
+
+// slackDisplayText turns Slack mrkdwn into the text Slack would show for it,
+// for places which can't show mrkdwn like meme captions and image alt text.
+// Links and mentions are replaced by their labels, code loses its backticks,
+// entities are unescaped, and formatting markers and emoji shortcodes are
+// dropped. Mentions without a label can't be resolved and are dropped too.
+func slackDisplayText(text string) string {
+	var buffer bytes.Buffer
+	last := 0
+	for _, m := range slackMarkupRegex.FindAllStringSubmatchIndex(text, -1) {
+		writeSlackPlain(&buffer, text, last, m[0])
+		last = m[1]
+		switch {
+		case m[2] >= 0:
+			buffer.WriteString(strings.Trim(text[m[2]:m[3]], "\n"))
+		case m[4] >= 0:
+			buffer.WriteString(text[m[4]:m[5]])
+		case m[6] >= 0:
+			buffer.WriteString(slackLinkText(text[m[6]:m[7]]))
+		case isSlackWordRune(lastRune(text[:m[0]])):
+			// not a shortcode, like the :30: in 10:30:45
+			buffer.WriteString(text[m[0]:m[1]])
+		}
+	}
+	writeSlackPlain(&buffer, text, last, len(text))
+	res := slackSpaceRegex.ReplaceAllString(html.UnescapeString(buffer.String()), " ")
+	return strings.TrimSpace(res)
+}
+
+// slackLinkText returns the text shown for the inside of a <...> link or
+// mention.
+func slackLinkText(link string) string {
+	var label string
+	if i := strings.IndexByte(link, '|'); i >= 0 {
+		link, label = link[:i], link[i+1:]
+	}
+	switch {
+	case strings.HasPrefix(link, "@"):
+		if label == "" {
+			return ""
+		}
+		return "@" + strings.TrimPrefix(label, "@")
+	case strings.HasPrefix(link, "#"):
+		if label == "" {
+			return ""
+		}
+		return "#" + strings.TrimPrefix(label, "#")
+	case link == "!here" || link == "!channel" || link == "!everyone":
+		return "@" + link[1:]
+	case strings.HasPrefix(link, "!"):
+		// subteams and dates carry their own label
+		return label
+	case label != "":
+		return label
+	default:
+		return strings.TrimPrefix(link, "mailto:")
+	}
+}
+
+// writeSlackPlain writes text[start:end] without bold, italic and
+// strikethrough markers. A marker is only formatting if it's at the edge of
+// a word, so snake_case and 2 * 3 are left alone.
+func writeSlackPlain(buffer *bytes.Buffer, text string, start, end int) {
+	for i := start; i < end; {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if r == '*' || r == '_' || r == '~' {
+			prev := lastRune(text[:i])
+			next, _ := utf8.DecodeRuneInString(text[i+size:])
+			opening := !isSlackWordRune(prev) && next != utf8.RuneError && !unicode.IsSpace(next)
+			closing := prev != utf8.RuneError && !unicode.IsSpace(prev) && !isSlackWordRune(next)
+			if opening || closing {
+				i += size
+				continue
+			}
+		}
+		buffer.WriteString(text[i : i+size])
+		i += size
+	}
+}
+
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
+
+func isSlackWordRune(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rjchee/spongemock/meme"
+)
+
+func TestSlackDisplayText(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain text", "plain text"},
+		{"hey <@U123ABC|bob> look", "hey @bob look"},
+		{"hey <@U123ABC> look", "hey look"},
+		{"in <#C123|general>", "in #general"},
+		{"<!here> <!channel>", "@here @channel"},
+		{"<!subteam^S123|@devs> hi", "@devs hi"},
+		{"see <https://example.com|this> or <https://example.org>", "see this or https://example.org"},
+		{"<mailto:bob@example.com>", "bob@example.com"},
+		{"a &amp; b &lt;c&gt;", "a & b <c>"},
+		{"nice :smile: one :wave::skin-tone-2:", "nice one"},
+		{"at 10:30:45", "at 10:30:45"},
+		{"*bold* _italic_ ~struck~", "bold italic struck"},
+		{"*bold <@U1|bob>*", "bold @bob"},
+		{"snake_case and 2 * 3", "snake_case and 2 * 3"},
+		{"run `go test` now", "run go test now"},
+		{"```\ncode *here*\n```", "code *here*"},
+	}
+	for _, tt := range tests {
+		if got := slackDisplayText(tt.in); got != tt.want {
+			t.Errorf("slackDisplayText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSlackMemeURLRendersDisplayText(t *testing.T) {
+	c, err := meme.LoadCatalog("../../static/templates.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "memes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	memeTemplates = c
+	memeRecords = newMemoryMemeStore()
+	memeCacheDir = dir
+	defer func() {
+		memeTemplates = nil
+		memeRecords = nil
+		os.RemoveAll(dir)
+	}()
+
+	mockedText := "*YoU* ArE <@U123ABC|bob> &amp; :smile: nOt"
+	u := slackMemeURL(mockedText, c.Default())
+	m := memePathRegex.FindStringSubmatch(strings.TrimPrefix(u, AppURL))
+	if m == nil {
+		t.Fatalf("slackMemeURL returned %q, want a rendered meme", u)
+	}
+	rec, err := memeRecords.Get(m[1])
+	if err != nil || rec == nil {
+		t.Fatalf("no meme record for %s: %v", m[1], err)
+	}
+	if want := "YoU ArE @bob & nOt"; rec.Text != want {
+		t.Errorf("meme caption is %q, want %q", rec.Text, want)
+	}
+	data, err := renderMeme(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) == 0 {
+		t.Error("rendered meme is empty")
+	}
+}
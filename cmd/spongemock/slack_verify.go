@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	slackMaxBodySize      = 1024 * 1024
+	slackSignatureVersion = "v0"
+	// slackReplayWindow is how far a request timestamp may be from the
+	// current time before the request is rejected as a possible replay.
+	slackReplayWindow = 5 * time.Minute
+)
+
+var errNoSlackSignature = errors.New("request is not signed")
+
+// verifySlackRequest wraps h so that it only handles requests which come
+// from Slack. Requests are verified with the signing secret if one is
+// configured and with the deprecated verification token otherwise. When
+// both are configured, either one is accepted so that apps can move to
+// signed requests without downtime.
+//
+// The request body is restored before h is called, so h can parse it as
+// usual.
+func verifySlackRequest(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, slackMaxBodySize))
+		r.Body.Close()
+		if err != nil {
+			log.Printf("error reading slack request body: %s\n", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		if err := checkSlackRequest(r.Header, body, time.Now()); err != nil {
+			log.Printf("rejected slack request to %s: %s\n", r.URL.Path, err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func checkSlackRequest(header http.Header, body []byte, now time.Time) error {
+	if slackSigningSecret != "" {
+		err := checkSlackSignature(header, body, now)
+		// a request with a bad signature is never accepted, but unsigned
+		// requests may still have a verification token
+		if err != errNoSlackSignature || slackVerificationToken == "" {
+			return err
+		}
+	}
+	return checkSlackToken(header, body)
+}
+
+// checkSlackSignature checks the X-Slack-Signature header, which is an
+// HMAC-SHA256 of the version, timestamp and body keyed by the signing secret.
+func checkSlackSignature(header http.Header, body []byte, now time.Time) error {
+	sig := header.Get("X-Slack-Signature")
+	ts := header.Get("X-Slack-Request-Timestamp")
+	if sig == "" && ts == "" {
+		return errNoSlackSignature
+	}
+
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid request timestamp %s", ts)
+	}
+	if d := now.Sub(time.Unix(secs, 0)); d > slackReplayWindow || d < -slackReplayWindow {
+		return fmt.Errorf("request timestamp %s is outside of the replay window", ts)
+	}
+
+	if !strings.HasPrefix(sig, slackSignatureVersion+"=") {
+		return fmt.Errorf("unknown signature version in %s", sig)
+	}
+	got, err := hex.DecodeString(sig[len(slackSignatureVersion)+1:])
+	if err != nil {
+		return errors.New("signature is not hex encoded")
+	}
+	mac := hmac.New(sha256.New, []byte(slackSigningSecret))
+	mac.Write([]byte(slackSignatureVersion + ":" + ts + ":"))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// checkSlackToken checks the verification token that Slack includes in the
+// request body. Depending on the kind of request, the token is a form value,
+// a field of a JSON form value named payload, or a field of a JSON body.
+func checkSlackToken(header http.Header, body []byte) error {
+	if slackVerificationToken == "" {
+		return errNoSlackSignature
+	}
+
+	var token struct {
+		Token string `json:"token"`
+	}
+	mt, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if mt == "application/json" {
+		if err := json.Unmarshal(body, &token); err != nil {
+			return fmt.Errorf("invalid json body: %s", err)
+		}
+	} else {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return fmt.Errorf("invalid form data: %s", err)
+		}
+		if payload := form.Get("payload"); payload != "" {
+			if err := json.Unmarshal([]byte(payload), &token); err != nil {
+				return fmt.Errorf("invalid json payload: %s", err)
+			}
+		} else {
+			token.Token = form.Get("token")
+		}
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token.Token), []byte(slackVerificationToken)) != 1 {
+		return errors.New("invalid verification token")
+	}
+	return nil
+}
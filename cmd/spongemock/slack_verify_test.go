@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSlackSigningSecret = "8f742231b10e8888abcd99yyyzzz85a5"
+
+// signSlackRequest sets the headers Slack signs requests with.
+func signSlackRequest(header http.Header, body string, ts time.Time) {
+	t := strconv.FormatInt(ts.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(testSlackSigningSecret))
+	mac.Write([]byte("v0:" + t + ":" + body))
+	header.Set("X-Slack-Request-Timestamp", t)
+	header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+}
+
+// setSlackSecrets sets the secrets requests are checked with, and returns a
+// function restoring the old ones.
+func setSlackSecrets(signingSecret, verificationToken string) func() {
+	oldSecret, oldToken := slackSigningSecret, slackVerificationToken
+	slackSigningSecret, slackVerificationToken = signingSecret, verificationToken
+	return func() {
+		slackSigningSecret, slackVerificationToken = oldSecret, oldToken
+	}
+}
+
+func TestCheckSlackSignature(t *testing.T) {
+	defer setSlackSecrets(testSlackSigningSecret, "")()
+	body := "token=xyz&team_id=T1&command=%2Fspongemock&text=hi"
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		modify func(http.Header)
+		body   string
+		now    time.Time
+		ok     bool
+	}{
+		{name: "valid", body: body, now: now, ok: true},
+		{name: "just inside the window", body: body, now: now.Add(slackReplayWindow - time.Second), ok: true},
+		{name: "old", body: body, now: now.Add(slackReplayWindow + time.Second)},
+		{name: "from the future", body: body, now: now.Add(-slackReplayWindow - time.Second)},
+		{name: "tampered body", body: body + "x", now: now},
+		{
+			name:   "wrong secret",
+			body:   body,
+			now:    now,
+			modify: func(h http.Header) { h.Set("X-Slack-Signature", "v0="+strings.Repeat("00", sha256.Size)) },
+		},
+		{
+			name:   "unknown version",
+			body:   body,
+			now:    now,
+			modify: func(h http.Header) { h.Set("X-Slack-Signature", "v1="+h.Get("X-Slack-Signature")[3:]) },
+		},
+		{
+			name:   "bad timestamp",
+			body:   body,
+			now:    now,
+			modify: func(h http.Header) { h.Set("X-Slack-Request-Timestamp", "yesterday") },
+		},
+		{
+			name: "unsigned",
+			body: body,
+			now:  now,
+			modify: func(h http.Header) {
+				h.Del("X-Slack-Signature")
+				h.Del("X-Slack-Request-Timestamp")
+			},
+		},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		signSlackRequest(header, body, now)
+		if tt.modify != nil {
+			tt.modify(header)
+		}
+		err := checkSlackRequest(header, []byte(tt.body), tt.now)
+		if tt.ok && err != nil {
+			t.Errorf("%s: checkSlackRequest failed: %s", tt.name, err)
+		} else if !tt.ok && err == nil {
+			t.Errorf("%s: checkSlackRequest succeeded", tt.name)
+		}
+	}
+}
+
+func TestCheckSlackToken(t *testing.T) {
+	defer setSlackSecrets("", "xyz")()
+	tests := []struct {
+		contentType string
+		body        string
+		ok          bool
+	}{
+		{"application/x-www-form-urlencoded", "token=xyz&text=hi", true},
+		{"application/x-www-form-urlencoded", "token=abc&text=hi", false},
+		{"application/x-www-form-urlencoded", "text=hi", false},
+		{"application/x-www-form-urlencoded", "payload=%7B%22token%22%3A%22xyz%22%7D", true},
+		{"application/x-www-form-urlencoded", "payload=%7B%22token%22%3A%22abc%22%7D", false},
+		{"application/json", `{"token":"xyz","type":"url_verification"}`, true},
+		{"application/json; charset=utf-8", `{"token":"abc"}`, false},
+	}
+	for _, tt := range tests {
+		header := http.Header{"Content-Type": {tt.contentType}}
+		err := checkSlackRequest(header, []byte(tt.body), time.Now())
+		if tt.ok && err != nil {
+			t.Errorf("checkSlackRequest(%s %s) failed: %s", tt.contentType, tt.body, err)
+		} else if !tt.ok && err == nil {
+			t.Errorf("checkSlackRequest(%s %s) succeeded", tt.contentType, tt.body)
+		}
+	}
+}
+
+func TestCheckSlackRequestDuringMigration(t *testing.T) {
+	defer setSlackSecrets(testSlackSigningSecret, "xyz")()
+	body := "token=xyz&text=hi"
+	header := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+	// unsigned requests fall back to the verification token
+	if err := checkSlackRequest(header, []byte(body), time.Now()); err != nil {
+		t.Errorf("unsigned request with a valid token was rejected: %s", err)
+	}
+	// but a bad signature is never accepted
+	signSlackRequest(header, body, time.Now())
+	if err := checkSlackRequest(header, []byte(body+"&x=1"), time.Now()); err == nil {
+		t.Error("request with a bad signature and a valid token was accepted")
+	}
+}
+
+func TestVerifySlackRequestRestoresBody(t *testing.T) {
+	defer setSlackSecrets(testSlackSigningSecret, "")()
+	body := "text=hello"
+	var got string
+	h := verifySlackRequest(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		got = r.PostFormValue("text")
+	})
+
+	r := httptest.NewRequest("POST", "/slack", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	signSlackRequest(r.Header, body, time.Now())
+	w := httptest.NewRecorder()
+	h(w, r)
+	if w.Code != http.StatusOK || got != "hello" {
+		t.Errorf("signed request got status %d and text %q", w.Code, got)
+	}
+
+	r = httptest.NewRequest("POST", "/slack", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	h(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned request got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
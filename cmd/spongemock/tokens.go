@@ -0,0 +1,269 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/rjchee/spongemock/secret"
+)
+
+var tokenKeys *secret.Keyring
+
+// tokenColumn is a database column of encrypted credentials. Each column has
+// a key_id column next to it naming the key its row was encrypted with, where
+// an empty key ID means the row was stored before encryption.
+type tokenColumn struct {
+	table  string
+	column string
+	// primaryKey are the columns identifying a row, which are
+	// authenticated along with the token.
+	primaryKey []string
+}
+
+// storedTokens lists every credential the app persists.
+var storedTokens = []tokenColumn{
+	{"slack_teams", "bot_token", []string{"team_id"}},
+	{"slack_user_tokens", "token", []string{"team_id", "user_id"}},
+}
+
+// warnLegacySlackTokens logs a reminder to migrate the table of plaintext
+// user tokens from before the app was installed per workspace, if it's still
+// there.
+func warnLegacySlackTokens() error {
+	exists, err := tableExists("slack_oauth")
+	if err != nil {
+		return fmt.Errorf("error checking for legacy slack oauth table: %s", err)
+	}
+	if exists {
+		log.Println("the legacy slack_oauth table holds plaintext tokens, run `spongemock migrate-slack-tokens` to encrypt them")
+	}
+	return nil
+}
+
+// migrateLegacySlackTokens moves the plaintext user tokens from before the
+// app was installed per workspace into slack_user_tokens, then drops their
+// table. Those rows don't say which workspace they belong to, so each token
+// is asked with auth.test. Tokens Slack no longer accepts are dropped, and
+// tokens users have since granted again are kept.
+func migrateLegacySlackTokens() error {
+	if DB == nil {
+		return errors.New("database required to migrate tokens")
+	}
+	exists, err := tableExists("slack_oauth")
+	if err != nil {
+		return fmt.Errorf("error checking for legacy slack oauth table: %s", err)
+	}
+	if !exists {
+		log.Println("no legacy slack_oauth table to migrate")
+		return nil
+	}
+	if err := setupOAuthDB(); err != nil {
+		return err
+	}
+
+	rows, err := DB.Query("SELECT user_id, token FROM slack_oauth;")
+	if err != nil {
+		return fmt.Errorf("error reading legacy slack tokens: %s", err)
+	}
+	type legacyToken struct {
+		userID, token string
+	}
+	var legacy []legacyToken
+	for rows.Next() {
+		var t legacyToken
+		if err := rows.Scan(&t.userID, &t.token); err != nil {
+			rows.Close()
+			return fmt.Errorf("error reading legacy slack tokens: %s", err)
+		}
+		legacy = append(legacy, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading legacy slack tokens: %s", err)
+	}
+
+	var moved, revoked int
+	for _, t := range legacy {
+		var auth struct {
+			TeamID string `json:"team_id"`
+			UserID string `json:"user_id"`
+		}
+		err := callSlackAPI(t.token, "auth.test", nil, &auth)
+		if isInvalidSlackToken(err) {
+			log.Printf("dropping revoked legacy slack token of %s\n", t.userID)
+			revoked++
+			continue
+		} else if err != nil {
+			// keep the table so that the migration can be run again
+			return fmt.Errorf("error looking up the workspace of %s: %s", t.userID, err)
+		}
+		keyID, sealed, err := encryptToken(t.token, tokenAD("slack_user_tokens", auth.TeamID, auth.UserID))
+		if err != nil {
+			return err
+		}
+		_, err = DB.Exec("INSERT INTO slack_user_tokens (team_id, user_id, token, key_id) VALUES ($1, $2, $3, $4) ON CONFLICT (team_id, user_id) DO NOTHING;", auth.TeamID, auth.UserID, sealed, keyID)
+		if err != nil {
+			return fmt.Errorf("error adding user token to database: %s", err)
+		}
+		log.Printf("moved legacy slack token of %s to team %s\n", t.userID, auth.TeamID)
+		moved++
+	}
+
+	if _, err := DB.Exec("DROP TABLE slack_oauth;"); err != nil {
+		return fmt.Errorf("error dropping legacy slack oauth table: %s", err)
+	}
+	log.Printf("moved %d legacy slack tokens and dropped %d revoked ones\n", moved, revoked)
+	return nil
+}
+
+// setupTokenKeys loads the keys credentials are encrypted with from
+// $TOKEN_KEYS or the file named by $TOKEN_KEYS_FILE.
+func setupTokenKeys() error {
+	keys := os.Getenv("TOKEN_KEYS")
+	if path := os.Getenv("TOKEN_KEYS_FILE"); path != "" {
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading token key file: %s", err)
+		}
+		keys = string(raw)
+	}
+	if keys == "" {
+		return errors.New("$TOKEN_KEYS or $TOKEN_KEYS_FILE must be set to store tokens")
+	}
+	k, err := secret.ParseKeyring(keys)
+	if err != nil {
+		return fmt.Errorf("invalid token keys: %s", err)
+	}
+	tokenKeys = k
+	return nil
+}
+
+// addTokenKeyColumns adds key ID columns to tables created before tokens were
+// encrypted.
+func addTokenKeyColumns() error {
+	for _, c := range storedTokens {
+		_, err := DB.Exec("ALTER TABLE " + c.table + " ADD COLUMN IF NOT EXISTS key_id text NOT NULL DEFAULT '';")
+		if err != nil {
+			return fmt.Errorf("error adding key id column to %s: %s", c.table, err)
+		}
+	}
+	return nil
+}
+
+// tokenAD returns the additional data a token is encrypted with, which ties
+// the token to its row.
+func tokenAD(table string, ids ...string) []byte {
+	return []byte(table + ":" + strings.Join(ids, ":"))
+}
+
+// encryptToken returns the key ID and sealed value to store for a token.
+func encryptToken(token string, ad []byte) (string, string, error) {
+	keyID, sealed, err := tokenKeys.Encrypt([]byte(token), ad)
+	if err != nil {
+		return "", "", fmt.Errorf("error encrypting token: %s", err)
+	}
+	return keyID, sealed, nil
+}
+
+func decryptToken(keyID, sealed string, ad []byte) (string, error) {
+	if keyID == "" {
+		// stored before tokens were encrypted
+		return sealed, nil
+	}
+	token, err := tokenKeys.Decrypt(keyID, sealed, ad)
+	if err != nil {
+		return "", fmt.Errorf("error decrypting token: %s", err)
+	}
+	return string(token), nil
+}
+
+// rekeyTokens encrypts every stored credential with the current key. Tokens
+// stored before encryption are encrypted, and the rest have their data keys
+// re-encrypted.
+func rekeyTokens() error {
+	if DB == nil {
+		return errors.New("database required to rekey tokens")
+	}
+	if err := setupTokenKeys(); err != nil {
+		return err
+	}
+	if err := addTokenKeyColumns(); err != nil {
+		return err
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting rekey transaction: %s", err)
+	}
+	for _, c := range storedTokens {
+		n, err := c.rekey(tx)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		log.Printf("re-encrypted %d rows of %s with key %s\n", n, c.table, tokenKeys.CurrentID())
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing rekey transaction: %s", err)
+	}
+	return nil
+}
+
+func (c tokenColumn) rekey(tx *sql.Tx) (int, error) {
+	pk := strings.Join(c.primaryKey, ", ")
+	rows, err := tx.Query("SELECT "+pk+", key_id, "+c.column+" FROM "+c.table+" WHERE key_id<>$1 FOR UPDATE;", tokenKeys.CurrentID())
+	if err != nil {
+		return 0, fmt.Errorf("error reading %s: %s", c.table, err)
+	}
+	type row struct {
+		ids           []string
+		keyID, sealed string
+	}
+	var stale []row
+	for rows.Next() {
+		r := row{ids: make([]string, len(c.primaryKey))}
+		dest := make([]interface{}, 0, len(c.primaryKey)+2)
+		for i := range r.ids {
+			dest = append(dest, &r.ids[i])
+		}
+		dest = append(dest, &r.keyID, &r.sealed)
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error reading %s: %s", c.table, err)
+		}
+		stale = append(stale, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error reading %s: %s", c.table, err)
+	}
+
+	var where []string
+	for i, col := range c.primaryKey {
+		where = append(where, fmt.Sprintf("%s=$%d", col, i+3))
+	}
+	update := "UPDATE " + c.table + " SET key_id=$1, " + c.column + "=$2 WHERE " + strings.Join(where, " AND ") + ";"
+	for _, r := range stale {
+		var keyID, sealed string
+		if r.keyID == "" {
+			keyID, sealed, err = encryptToken(r.sealed, tokenAD(c.table, r.ids...))
+		} else {
+			keyID, sealed, err = tokenKeys.Rotate(r.keyID, r.sealed)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("error re-encrypting %s row %s: %s", c.table, strings.Join(r.ids, "/"), err)
+		}
+		args := []interface{}{keyID, sealed}
+		for _, id := range r.ids {
+			args = append(args, id)
+		}
+		if _, err := tx.Exec(update, args...); err != nil {
+			return 0, fmt.Errorf("error updating %s: %s", c.table, err)
+		}
+	}
+	return len(stale), nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+var twitterConsumerSecret string
+
+// twitterPlugin receives Account Activity webhooks for the Twitter bot. The
+// bot itself runs in the worker, which handles the activity the web process
+// queues for it.
+type twitterPlugin struct{}
+
+func (p twitterPlugin) EnvVariables() []EnvVariable {
+	return []EnvVariable{
+		{
+			Name:     "TWITTER_CONSUMER_SECRET",
+			Variable: &twitterConsumerSecret,
+			Optional: true,
+		},
+	}
+}
+
+func (p twitterPlugin) RegisterHandles(m *http.ServeMux) {
+	if twitterConsumerSecret == "" {
+		log.Println("$TWITTER_CONSUMER_SECRET is not set, so twitter webhooks will not be received")
+		return
+	}
+	if err := setupTwitterActivityQueue(); err != nil {
+		log.Printf("error setting up the twitter activity queue: %s\n", err)
+		log.Println("twitter webhooks will not be received")
+		return
+	}
+	m.Handle(twitterWebhookRoute, twitterWebhookHandler(queueTwitterActivity))
+}
+
+func (p twitterPlugin) Name() string {
+	return "twitter"
+}
+
+func NewTwitterPlugin() WebPlugin {
+	return twitterPlugin{}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	twitterWebhookRoute     = "/twitter/webhook"
+	twitterSignatureHeader  = "X-Twitter-Webhooks-Signature"
+	twitterSignaturePrefix  = "sha256="
+	twitterWebhookBodyLimit = 1 << 20
+	// twitterActivityChannel is notified whenever activity is queued, so
+	// the worker can handle it right away.
+	twitterActivityChannel = "twitter_activity"
+	// twitterActivityTableSchema is the queue of webhook payloads. The
+	// worker counts its attempts at handling a payload, and waits until
+	// retry_at before trying one again.
+	twitterActivityTableSchema = "(id bigserial PRIMARY KEY, payload text NOT NULL, received timestamptz NOT NULL DEFAULT now(), attempts integer NOT NULL DEFAULT 0, retry_at timestamptz NOT NULL DEFAULT now())"
+)
+
+// setupTwitterActivityQueue creates the table the worker reads webhook
+// payloads from.
+func setupTwitterActivityQueue() error {
+	if DB == nil {
+		return errors.New("database required to pass twitter activity to the worker")
+	}
+	if err := createTable("twitter_activity", twitterActivityTableSchema); err != nil {
+		return fmt.Errorf("error creating twitter activity table: %s", err)
+	}
+	return nil
+}
+
+// queueTwitterActivity stores a payload for the worker and notifies it.
+func queueTwitterActivity(payload []byte) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting twitter activity transaction: %s", err)
+	}
+	if _, err := tx.Exec("INSERT INTO twitter_activity (payload) VALUES ($1);", string(payload)); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error queueing twitter activity: %s", err)
+	}
+	// the notification is only sent once the transaction commits
+	if _, err := tx.Exec("NOTIFY " + twitterActivityChannel + ";"); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error notifying the worker of twitter activity: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing twitter activity: %s", err)
+	}
+	return nil
+}
+
+// twitterWebhookHandler answers CRC challenges and passes the payload of
+// every correctly signed event delivery to dispatch.
+func twitterWebhookHandler(dispatch func([]byte) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			handleTwitterCRC(w, r)
+		case "POST":
+			handleTwitterActivity(w, r, dispatch)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// handleTwitterCRC answers the challenge Twitter sends when the webhook is
+// registered and periodically after that.
+func handleTwitterCRC(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("crc_token")
+	if token == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	output, err := json.Marshal(map[string]string{
+		"response_token": twitterSignaturePrefix + signTwitterPayload([]byte(token)),
+	})
+	if err != nil {
+		log.Printf("error marshalling crc response: %s\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(output)
+}
+
+func handleTwitterActivity(w http.ResponseWriter, r *http.Request, dispatch func([]byte) error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, twitterWebhookBodyLimit))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !validTwitterSignature(r.Header.Get(twitterSignatureHeader), body) {
+		log.Println("rejecting twitter webhook with an invalid signature")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var payload json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		log.Println("rejecting twitter webhook with an invalid json body")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// Twitter retries deliveries which fail, so only acknowledge the
+	// payload once the worker is sure to see it
+	if err := dispatch(body); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func validTwitterSignature(header string, body []byte) bool {
+	if !strings.HasPrefix(header, twitterSignaturePrefix) {
+		return false
+	}
+	return hmac.Equal([]byte(header[len(twitterSignaturePrefix):]), []byte(signTwitterPayload(body)))
+}
+
+// signTwitterPayload returns the base64 encoded HMAC of a payload, keyed with
+// the consumer secret.
+func signTwitterPayload(payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(twitterConsumerSecret))
+	mac.Write(payload)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
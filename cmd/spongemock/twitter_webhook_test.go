@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+const testTwitterConsumerSecret = "consumer-secret"
+
+// twitterPayloads returns the recorded webhook payloads used by
+// cmd/twitter-replay.
+func twitterPayloads(t *testing.T) map[string][]byte {
+	paths, err := filepath.Glob("../twitter-replay/testdata/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no recorded twitter payloads")
+	}
+	payloads := make(map[string][]byte)
+	for _, path := range paths {
+		payload, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payloads[filepath.Base(path)] = payload
+	}
+	return payloads
+}
+
+func signTwitterTestPayload(payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(testTwitterConsumerSecret))
+	mac.Write(payload)
+	return "sha256=" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// setTwitterConsumerSecret sets the secret payloads are signed with, and
+// returns a function restoring the old one.
+func setTwitterConsumerSecret() func() {
+	old := twitterConsumerSecret
+	twitterConsumerSecret = testTwitterConsumerSecret
+	return func() {
+		twitterConsumerSecret = old
+	}
+}
+
+func TestTwitterWebhookCRC(t *testing.T) {
+	defer setTwitterConsumerSecret()()
+	h := twitterWebhookHandler(func([]byte) error {
+		t.Error("crc check dispatched activity")
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", twitterWebhookRoute+"?crc_token=challenge", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("crc check got status %d", w.Code)
+	}
+	var res struct {
+		ResponseToken string `json:"response_token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("invalid crc response %s: %s", w.Body, err)
+	}
+	if want := signTwitterTestPayload([]byte("challenge")); res.ResponseToken != want {
+		t.Errorf("response_token = %s, want %s", res.ResponseToken, want)
+	}
+
+	w = httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", twitterWebhookRoute, nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("crc check without a token got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestTwitterWebhookDispatchesSignedPayloads(t *testing.T) {
+	defer setTwitterConsumerSecret()()
+	for name, payload := range twitterPayloads(t) {
+		var dispatched [][]byte
+		h := twitterWebhookHandler(func(p []byte) error {
+			dispatched = append(dispatched, p)
+			return nil
+		})
+
+		r := httptest.NewRequest("POST", twitterWebhookRoute, bytes.NewReader(payload))
+		r.Header.Set(twitterSignatureHeader, signTwitterTestPayload(payload))
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got status %d", name, w.Code)
+		}
+		if len(dispatched) != 1 || !bytes.Equal(dispatched[0], payload) {
+			t.Errorf("%s: dispatched %q, want the payload once", name, dispatched)
+		}
+	}
+}
+
+func TestTwitterWebhookRejectsBadSignatures(t *testing.T) {
+	defer setTwitterConsumerSecret()()
+	h := twitterWebhookHandler(func([]byte) error {
+		t.Error("payload with a bad signature was dispatched")
+		return nil
+	})
+	for name, payload := range twitterPayloads(t) {
+		sig := signTwitterTestPayload(payload)
+		for _, header := range []string{
+			"",
+			sig[len(twitterSignaturePrefix):],
+			signTwitterTestPayload(append([]byte(" "), payload...)),
+			"sha256=" + base64.StdEncoding.EncodeToString([]byte("forged")),
+		} {
+			r := httptest.NewRequest("POST", twitterWebhookRoute, bytes.NewReader(payload))
+			if header != "" {
+				r.Header.Set(twitterSignatureHeader, header)
+			}
+			w := httptest.NewRecorder()
+			h(w, r)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("%s with signature %q: got status %d, want %d", name, header, w.Code, http.StatusUnauthorized)
+			}
+		}
+	}
+}
+
+func TestTwitterWebhookAsksForRetryWhenDispatchFails(t *testing.T) {
+	defer setTwitterConsumerSecret()()
+	h := twitterWebhookHandler(func([]byte) error {
+		return errors.New("database is down")
+	})
+	payload := []byte(`{"for_user_id": "1"}`)
+	r := httptest.NewRequest("POST", twitterWebhookRoute, bytes.NewReader(payload))
+	r.Header.Set(twitterSignatureHeader, signTwitterTestPayload(payload))
+	w := httptest.NewRecorder()
+	h(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestTwitterWebhookRejectsInvalidJSON(t *testing.T) {
+	defer setTwitterConsumerSecret()()
+	h := twitterWebhookHandler(func([]byte) error {
+		t.Error("invalid payload was dispatched")
+		return nil
+	})
+	payload := []byte(`{"for_user_id": `)
+	r := httptest.NewRequest("POST", twitterWebhookRoute, bytes.NewReader(payload))
+	r.Header.Set(twitterSignatureHeader, signTwitterTestPayload(payload))
+	w := httptest.NewRecorder()
+	h(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/rjchee/spongemock/mock"
+)
+
+var webPage = template.Must(template.New("web").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Spongemock</title>
+<link rel="icon" href="/static/icon.png">
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 0 auto; padding: 1em; }
+textarea { width: 100%; box-sizing: border-box; font-size: 1em; }
+label { display: inline-block; margin: 0.5em 1em 0.5em 0; }
+img { max-width: 100%; }
+.error { color: #b00; }
+.result { margin-top: 2em; }
+</style>
+</head>
+<body>
+<h1><img src="/static/icon.png" alt="" width="48" height="48"> Spongemock</h1>
+<form method="post" action="/">
+<textarea name="text" rows="4" placeholder="Type something to mock" required>{{.Text}}</textarea>
+<label>Style
+<select name="style">
+<option value="">the meme's default</option>
+{{- range .Styles}}
+<option value="{{.Name}}"{{if eq .Name $.Style}} selected{{end}}>{{.Name}}: {{.Description}}</option>
+{{- end}}
+</select>
+</label>
+{{- if .Templates}}
+<label>Meme
+<select name="template">
+{{- range .Templates}}
+<option value="{{.Name}}"{{if eq .Name $.Template}} selected{{end}}>{{.Name}}: {{.Description}}</option>
+{{- end}}
+</select>
+</label>
+{{- end}}
+<button type="submit">Mock it</button>
+</form>
+{{- if .Error}}
+<p class="error">{{.Error}}</p>
+{{- end}}
+{{- if .Result}}
+<div class="result">
+<textarea id="mocked" rows="4" readonly>{{.Result.Text}}</textarea>
+<button type="button" id="copy" hidden>Copy text</button>
+{{- if .Result.ImageURL}}
+<p><img src="{{.Result.ImageURL}}" alt="{{.Result.Text}}"></p>
+<p><a href="{{.Result.ImageURL}}" download="spongemock.png">Download the meme</a></p>
+{{- end}}
+</div>
+<script>
+var copy = document.getElementById("copy");
+if (navigator.clipboard) {
+	copy.hidden = false;
+	copy.addEventListener("click", function() {
+		navigator.clipboard.writeText(document.getElementById("mocked").value).then(function() {
+			copy.textContent = "Copied!";
+		});
+	});
+}
+</script>
+{{- end}}
+</body>
+</html>
+`))
+
+type webPageData struct {
+	Text      string
+	Style     string
+	Template  string
+	Styles    []*mock.Style
+	Templates []apiTemplate
+	Result    *apiMockResponse
+	Error     string
+}
+
+type webPlugin struct{}
+
+func (p webPlugin) EnvVariables() []EnvVariable {
+	return nil
+}
+
+func (p webPlugin) RegisterHandles(m *http.ServeMux) {
+	m.HandleFunc("/", handleWeb)
+}
+
+func (p webPlugin) Name() string {
+	return "web"
+}
+
+func NewWebPlugin() WebPlugin {
+	return webPlugin{}
+}
+
+func handleWeb(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "GET" && r.Method != "POST" {
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	data := webPageData{
+		Styles: mock.Styles(),
+	}
+	if memeTemplates != nil {
+		data.Template = memeTemplates.Default().Name
+		for _, t := range memeTemplates.Templates() {
+			data.Templates = append(data.Templates, apiTemplate{t.Name, t.Description, t.Style})
+		}
+	}
+
+	status := http.StatusOK
+	if r.Method == "POST" {
+		r.Body = http.MaxBytesReader(w, r.Body, apiMaxBodySize)
+		if err := r.ParseForm(); err != nil {
+			log.Printf("invalid form data: %s\n", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data.Text = r.PostFormValue("text")
+		data.Style = r.PostFormValue("style")
+		if t := r.PostFormValue("template"); t != "" {
+			data.Template = t
+		}
+		if !allowMock(w, r) {
+			status = http.StatusTooManyRequests
+			data.Error = "You're mocking too fast! Please wait a bit and try again."
+		} else if res, s, err := mockRequest(apiMockRequest{
+			Text:     data.Text,
+			Style:    data.Style,
+			Template: data.Template,
+		}); err != nil {
+			status = s
+			data.Error = err.Error()
+		} else {
+			data.Result = &res
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := webPage.Execute(&buf, data); err != nil {
+		log.Printf("error rendering web page: %s\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
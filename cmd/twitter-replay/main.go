@@ -0,0 +1,111 @@
+// Command twitter-replay stands in for Twitter's Account Activity API, so the
+// webhook can be exercised locally. It sends the webhook a CRC challenge and
+// checks the answer, then posts each recorded payload to it with a valid
+// signature.
+//
+// Usage:
+//
+//	TWITTER_CONSUMER_SECRET=... twitter-replay http://localhost:5000/twitter/webhook testdata/*.json
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatalf("usage: %s webhook-url [payload.json...]", os.Args[0])
+	}
+	secret := os.Getenv("TWITTER_CONSUMER_SECRET")
+	if secret == "" {
+		log.Fatal("$TWITTER_CONSUMER_SECRET must be set!")
+	}
+	webhook := os.Args[1]
+
+	if err := checkCRC(webhook, secret); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("crc check passed")
+
+	for _, path := range os.Args[2:] {
+		payload, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatalf("error reading payload: %s", err)
+		}
+		status, err := replay(webhook, secret, payload)
+		if err != nil {
+			log.Fatalf("error replaying %s: %s", path, err)
+		}
+		log.Printf("replayed %s: %s\n", path, status)
+	}
+}
+
+func sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkCRC sends a challenge the way Twitter does when a webhook is
+// registered, and checks the webhook's answer.
+func checkCRC(webhook, secret string) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("error generating crc token: %s", err)
+	}
+	token := hex.EncodeToString(b)
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url: %s", err)
+	}
+	q := u.Query()
+	q.Set("crc_token", token)
+	u.RawQuery = q.Encode()
+
+	res, err := http.Get(u.String())
+	if err != nil {
+		return fmt.Errorf("crc request error: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("crc response status: %s", res.Status)
+	}
+	var body struct {
+		ResponseToken string `json:"response_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return fmt.Errorf("error decoding crc response: %s", err)
+	}
+	if body.ResponseToken != sign(secret, []byte(token)) {
+		return errors.New("crc response token does not match")
+	}
+	return nil
+}
+
+func replay(webhook, secret string, payload []byte) (string, error) {
+	req, err := http.NewRequest("POST", webhook, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Twitter-Webhooks-Signature", sign(secret, payload))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	return res.Status, nil
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	_ "github.com/lib/pq"
+	"github.com/rjchee/spongemock/meme"
 )
 
 var (
@@ -19,11 +20,7 @@ var (
 	DEBUG   bool
 )
 
-const (
-	iconPath       = "static/icon.png"
-	memePath       = "static/spongemock.jpg"
-	groupThreshold = 0.8
-)
+const iconPath = "static/icon.png"
 
 type EnvVariable struct {
 	Name     string
@@ -36,17 +33,19 @@ type WorkerPlugin interface {
 	Start(chan<- error)
 }
 
-func init() {
+// setupGlobals reads the settings shared by every plugin. It's called from
+// main rather than init so that tests don't need a configured environment.
+func setupGlobals() {
 	SetEnvVariable("APP_URL", &AppURL)
 
 	u, err := url.Parse(AppURL)
 	if err != nil {
-		log.Fatal("invalid $APP_URL %s", AppURL)
+		log.Fatalf("invalid $APP_URL %s", AppURL)
 	}
 	icon, _ := url.Parse(iconPath)
 	IconURL = u.ResolveReference(icon).String()
-	meme, _ := url.Parse(memePath)
-	MemeURL = u.ResolveReference(meme).String()
+	memeImage, _ := url.Parse(meme.DefaultImagePath)
+	MemeURL = u.ResolveReference(memeImage).String()
 
 	dbURL := os.Getenv("DATABASE_URL")
 	if dbURL != "" {
@@ -23,6 +23,7 @@ func (e pluginError) Error() string {
 }
 
 func main() {
+	setupGlobals()
 	var plugins []WorkerPlugin
 	whitelist := os.Getenv("PLUGINS")
 	if whitelist == "" {
@@ -1,17 +1,17 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"regexp"
-	"strconv"
 	"strings"
 
 	"github.com/dghubble/go-twitter/twitter"
 	"github.com/dghubble/oauth1"
+	"github.com/rjchee/spongemock/meme"
 )
 
 var (
@@ -21,8 +21,16 @@ var (
 	twitterAuthToken      string
 	twitterAuthSecret     string
 
-	twitterAPIClient    *twitter.Client
-	twitterUploadClient *http.Client
+	// twitterUserID is the ID of the bot's account.
+	twitterUserID string
+
+	twitterAPIClient     *twitter.Client
+	twitterHTTPClient    *http.Client
+	twitterMemeTemplates *meme.Catalog
+
+	errOwnTweet   = errors.New("cannot mock my own tweet")
+	errRetweet    = errors.New("cannot mock a retweet")
+	errDebugTweet = errors.New("cannot send a tweet in DEBUG mode")
 
 	tweetURLPattern = regexp.MustCompile("^https?://twitter.com/\\w+/status/(?P<tweet_id>\\d+)$")
 )
@@ -69,41 +77,41 @@ func (p twitterPlugin) Start(ch chan<- error) {
 	token := oauth1.NewToken(twitterAuthToken, twitterAuthSecret)
 
 	httpClient := config.Client(oauth1.NoContext, token)
-	twitterUploadClient = httpClient
+	twitterHTTPClient = httpClient
 	twitterAPIClient = twitter.NewClient(httpClient)
 
-	handleOfflineActivity(ch)
-
-	stream, err := twitterAPIClient.Streams.User(&twitter.StreamUserParams{
-		With:          "user",
-		StallWarnings: twitter.Bool(true),
-	})
+	var err error
+	twitterMemeTemplates, err = meme.LoadDefaultCatalog()
 	if err != nil {
 		ch <- err
 		return
 	}
 
-	demux := twitter.NewSwitchDemux()
-	demux.Tweet = func(tweet *twitter.Tweet) {
-		handleTweet(tweet, ch, true)
-	}
-	demux.DM = func(dm *twitter.DirectMessage) {
-		handleDM(dm, ch)
+	user, resp, err := twitterAPIClient.Accounts.VerifyCredentials(nil)
+	if err != nil {
+		ch <- fmt.Errorf("verify credentials error: %s", err)
+		return
 	}
-	demux.StreamLimit = handleStreamLimit
-	demux.StreamDisconnect = handleStreamDisconnect
-	demux.Warning = handleWarning
-	demux.Other = handleOther
-
-	demux.HandleChan(stream.Messages)
-}
+	resp.Body.Close()
+	twitterUserID = user.IDStr
 
-func logMessage(msg interface{}, desc string) {
-	if msgJSON, err := json.MarshalIndent(msg, "", "  "); err == nil {
-		log.Printf("Received %s: %s\n", desc, string(msgJSON[:]))
-	} else {
-		logMessageStruct(msg, desc)
+	if strings.ToLower(os.Getenv("TWITTER_WEBHOOKS")) == "true" {
+		// catching up happens once the queue is drained
+		receiveTwitterActivity(ch)
+		return
+	}
+	if err := seedTwitterSinceIDs(); err != nil {
+		ch <- err
+		return
+	}
+	handleOfflineActivity(ch, false)
+	// fall back to polling without webhooks
+	interval, err := twitterPollInterval()
+	if err != nil {
+		ch <- err
+		return
 	}
+	pollTwitterActivity(interval, ch)
 }
 
 func logMessageStruct(msg interface{}, desc string) {
@@ -149,17 +157,31 @@ func extractText(tweet *twitter.Tweet) string {
 	return text
 }
 
+// canRetryTweet reports whether handling a tweet failed with err in a way
+// that trying again might fix.
+func canRetryTweet(err error) bool {
+	switch err {
+	case nil, errOwnTweet, errRetweet, errDebugTweet:
+		return false
+	}
+	return true
+}
+
 func handleTweet(tweet *twitter.Tweet, ch chan<- error, followQuoteRetweet bool) (*twitter.Tweet, error) {
 	switch {
 	case tweet.User.ScreenName == twitterUsername:
-		return nil, errors.New("cannot mock my own tweet")
+		return nil, errOwnTweet
 	case tweet.RetweetedStatus != nil:
-		return nil, errors.New("cannot mock a retweet")
+		return nil, errRetweet
 	}
 	logMessageStruct(tweet, "Tweet")
 
 	mentions := []string{"@" + tweet.User.ScreenName}
-	text := extractText(tweet)
+	// hashtags in the tweet mentioning the bot pick the style and template
+	style, template, text := extractOptions(extractText(tweet))
+	// seed the mocking with the ID of the mocked tweet so that handling the
+	// same tweet twice produces the same response
+	seed := tweet.ID
 	var err error
 	if tweet.InReplyToStatusIDStr == "" ||
 		!strings.Contains(text, "@"+twitterUsername) {
@@ -170,6 +192,7 @@ func handleTweet(tweet *twitter.Tweet, ch chan<- error, followQuoteRetweet bool)
 		if followQuoteRetweet && tweet.QuotedStatus != nil {
 			// quote retweets should mock the retweeted person if followQuoteRetweet is true
 			text = extractText(tweet.QuotedStatus)
+			seed = tweet.QuotedStatus.ID
 			mentions = append(mentions, "@"+tweet.QuotedStatus.User.ScreenName)
 		}
 	} else {
@@ -179,6 +202,7 @@ func handleTweet(tweet *twitter.Tweet, ch chan<- error, followQuoteRetweet bool)
 			ch <- err
 			return nil, err
 		}
+		seed = tweet.InReplyToStatusID
 		if tweet.InReplyToScreenName != twitterUsername {
 			mentions = append(mentions, "@"+tweet.InReplyToScreenName)
 		}
@@ -186,26 +210,25 @@ func handleTweet(tweet *twitter.Tweet, ch chan<- error, followQuoteRetweet bool)
 
 	log.Println("tweet text:", text)
 
-	finalTweets := finalizeTweet(mentions, text)
+	mockedText := twitterMocker.WithStyle(style).MockSeed(text, seed)
+	finalTweets := finalizeTweet(mentions, mockedText)
 
 	if DEBUG {
 		for _, finalTweet := range finalTweets {
 			log.Println("tweeting:", finalTweet)
 		}
-		return nil, errors.New("cannot send a tweet in DEBUG mode")
+		return nil, errDebugTweet
 	} else {
-		mediaID, mediaIDStr, cached, err := uploadImage()
+		mediaID, mediaIDStr, err := uploadImage(mockedText, template, twitterTweetImage)
 		if err != nil {
 			err = fmt.Errorf("upload image error: %s", err)
 			ch <- err
 			return nil, err
 		}
-		if !cached {
-			if err = uploadMetadata(mediaIDStr, text); err != nil {
-				// we can continue from a metadata upload error
-				// because it is not essential
-				ch <- fmt.Errorf("metadata upload error: %s", err)
-			}
+		if err = uploadMetadata(mediaIDStr, text); err != nil {
+			// we can continue from a metadata upload error
+			// because it is not essential
+			ch <- fmt.Errorf("metadata upload error: %s", err)
 		}
 
 		params := twitter.StatusUpdateParams{
@@ -237,101 +260,3 @@ func handleTweet(tweet *twitter.Tweet, ch chan<- error, followQuoteRetweet bool)
 		return res, nil
 	}
 }
-
-func extractTweetFromDM(dm *twitter.DirectMessage) (*twitter.Tweet, error) {
-	// Is this a link to a tweet?
-	if dm.Entities != nil {
-		for _, urlEntity := range dm.Entities.Urls {
-			if r := tweetURLPattern.FindStringSubmatch(urlEntity.ExpandedURL); r != nil {
-				if tweetID, err := strconv.ParseInt(r[1], 10, 64); err == nil {
-					// we don't need to check for errors at this point since it cannot be any other kind of message
-					return lookupTweet(tweetID)
-				} else {
-					panic(fmt.Errorf("tweetURLPattern regexp matched a tweet %s with an unparseable tweet ID %d", urlEntity.ExpandedURL, r[1]))
-				}
-			}
-		}
-	}
-	// is this a tweet ID?
-	if tweetID, err := strconv.ParseInt(dm.Text, 10, 64); err == nil {
-		if tweet, err := lookupTweet(tweetID); err == nil {
-			return tweet, nil
-		}
-	}
-
-	return nil, errors.New("no tweet found in dm")
-}
-
-func sendDM(text string, userID int64) (*twitter.DirectMessage, error) {
-	log.Printf("sending a dm to userID %d: %s\n", userID, text)
-	dm, resp, err := twitterAPIClient.DirectMessages.New(&twitter.DirectMessageNewParams{
-		UserID: userID,
-		Text:   text,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("new dm error: %s", err)
-	}
-	resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("new dm response status code : %d", resp.StatusCode)
-	}
-	return dm, nil
-}
-
-func handleDM(dm *twitter.DirectMessage, ch chan<- error) {
-	logMessageStruct(dm, "DM")
-	if dm.RecipientScreenName != twitterUsername {
-		// don't react these events
-		return
-	}
-
-	if tweet, err := extractTweetFromDM(dm); err != nil {
-		if dm.SenderScreenName != twitterUsername {
-			// no tweet found, just mock the user dm'ing the bot
-			responseText := transformTwitterText(dm.Text)
-			if DEBUG {
-				log.Println("dm'ing back:", responseText)
-			} else {
-				_, err := sendDM(responseText, dm.SenderID)
-				if err != nil {
-					ch <- err
-					return
-				}
-			}
-		} else {
-			log.Println("DM'd self with invalid message", dm.Text)
-		}
-	} else {
-		if tweet, err := handleTweet(tweet, ch, false); err != nil {
-			ch <- fmt.Errorf("error handling tweet from dm: %s", err)
-			_, err := sendDM(transformTwitterText("An error occurred. Please try again"), dm.SenderID)
-			if err != nil {
-				ch <- err
-				return
-			}
-		} else {
-			_, err := sendDM(fmt.Sprintf("https://twitter.com/%s/status/%s", twitterUsername, tweet.IDStr), dm.SenderID)
-			if err != nil {
-				ch <- err
-				return
-			}
-		}
-	}
-}
-
-func handleStreamLimit(sl *twitter.StreamLimit) {
-	logMessage(sl, "stream limit message")
-}
-
-func handleStreamDisconnect(sd *twitter.StreamDisconnect) {
-	logMessage(sd, "stream disconnect message")
-}
-
-func handleWarning(w *twitter.StallWarning) {
-	logMessage(w, "stall warning")
-}
-
-func handleOther(message interface{}) {
-	logMessage(message, `"other" message type`)
-}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/rjchee/spongemock/mock"
+)
+
+const (
+	twitterAPIURL = "https://api.twitter.com/1.1/"
+	// twitterDMPageSize is the most events events/list returns at once.
+	twitterDMPageSize = 50
+	// twitterDMCatchUpWindow is how far back DMs are caught up on when
+	// there's no record of the last one handled.
+	twitterDMCatchUpWindow = 24 * time.Hour
+	// twitterRestylePrefix starts the metadata of quick replies which mock
+	// text again in another style. The metadata is of the form
+	// restyle:style:text.
+	twitterRestylePrefix = "restyle:"
+	// twitterQuickReplyMetadataLen is the longest quick reply metadata
+	// Twitter accepts.
+	twitterQuickReplyMetadataLen = 1000
+)
+
+// twitterDMEvent is a direct message in the form of the Direct Message Events
+// API.
+type twitterDMEvent struct {
+	Type             string `json:"type"`
+	ID               string `json:"id,omitempty"`
+	CreatedTimestamp string `json:"created_timestamp,omitempty"`
+	MessageCreate    struct {
+		Target struct {
+			RecipientID string `json:"recipient_id"`
+		} `json:"target"`
+		SenderID    string               `json:"sender_id,omitempty"`
+		MessageData twitterDMMessageData `json:"message_data"`
+	} `json:"message_create"`
+}
+
+type twitterDMMessageData struct {
+	Text               string                     `json:"text"`
+	Entities           *twitter.Entities          `json:"entities,omitempty"`
+	QuickReply         *twitterQuickReply         `json:"quick_reply,omitempty"`
+	QuickReplyResponse *twitterQuickReplyResponse `json:"quick_reply_response,omitempty"`
+	Attachment         *twitterDMAttachment       `json:"attachment,omitempty"`
+}
+
+type twitterQuickReply struct {
+	Type    string                    `json:"type"`
+	Options []twitterQuickReplyOption `json:"options"`
+}
+
+type twitterQuickReplyOption struct {
+	Label       string `json:"label"`
+	Description string `json:"description,omitempty"`
+	Metadata    string `json:"metadata,omitempty"`
+}
+
+// twitterQuickReplyResponse is sent along with a DM when the user picks a
+// quick reply option.
+type twitterQuickReplyResponse struct {
+	Type     string `json:"type"`
+	Metadata string `json:"metadata"`
+}
+
+type twitterDMAttachment struct {
+	Type  string `json:"type"`
+	Media struct {
+		ID string `json:"id"`
+	} `json:"media"`
+}
+
+func (e *twitterDMEvent) id() int64 {
+	id, _ := strconv.ParseInt(e.ID, 10, 64)
+	return id
+}
+
+// created returns when the DM was sent, or the zero time if it's unknown.
+func (e *twitterDMEvent) created() time.Time {
+	ms, err := strconv.ParseInt(e.CreatedTimestamp, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+func (e *twitterDMEvent) senderID() string {
+	return e.MessageCreate.SenderID
+}
+
+func (e *twitterDMEvent) recipientID() string {
+	return e.MessageCreate.Target.RecipientID
+}
+
+func (e *twitterDMEvent) text() string {
+	return e.MessageCreate.MessageData.Text
+}
+
+type twitterDMEventResponse struct {
+	Event twitterDMEvent `json:"event"`
+}
+
+type twitterDMEventList struct {
+	Events     []twitterDMEvent `json:"events"`
+	NextCursor string           `json:"next_cursor"`
+}
+
+type twitterAPIErrors struct {
+	Errors []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// callTwitterAPI calls an endpoint the go-twitter client doesn't support,
+// sending body as JSON if it isn't nil and decoding the response into v.
+func callTwitterAPI(method, endpoint string, params url.Values, body, v interface{}) error {
+	u := twitterAPIURL + endpoint
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return fmt.Errorf("json marshal error: %s", err)
+		}
+	}
+	req, err := http.NewRequest(method, u, &reqBody)
+	if err != nil {
+		return fmt.Errorf("making http request error: %s", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := twitterHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s request error: %s", endpoint, err)
+	}
+	defer res.Body.Close()
+	raw, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("reading %s response error: %s", endpoint, err)
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		var apiErr twitterAPIErrors
+		if json.Unmarshal(raw, &apiErr) == nil && len(apiErr.Errors) > 0 {
+			return fmt.Errorf("%s error %d: %s", endpoint, apiErr.Errors[0].Code, apiErr.Errors[0].Message)
+		}
+		return fmt.Errorf("%s response status code: %d", endpoint, res.StatusCode)
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("unmarshalling %s response error: %s", endpoint, err)
+	}
+	return nil
+}
+
+// sendDM sends a direct message to the given user.
+func sendDM(userID string, data twitterDMMessageData) (*twitterDMEvent, error) {
+	log.Printf("sending a dm to userID %s: %s\n", userID, data.Text)
+	var e twitterDMEvent
+	e.Type = "message_create"
+	e.MessageCreate.Target.RecipientID = userID
+	e.MessageCreate.MessageData = data
+
+	var res twitterDMEventResponse
+	if err := callTwitterAPI("POST", "direct_messages/events/new.json", nil, twitterDMEventResponse{e}, &res); err != nil {
+		return nil, fmt.Errorf("new dm error: %s", err)
+	}
+	return &res.Event, nil
+}
+
+// getDMEvents returns the DMs sent and received after sinceID, newest first.
+// Without a sinceID, only the DMs from the last twitterDMCatchUpWindow are
+// returned rather than the 30 days Twitter keeps.
+func getDMEvents(sinceID int64) ([]twitterDMEvent, error) {
+	var res []twitterDMEvent
+	var cutoff time.Time
+	if sinceID == 0 {
+		cutoff = time.Now().Add(-twitterDMCatchUpWindow)
+	}
+	params := url.Values{"count": {strconv.Itoa(twitterDMPageSize)}}
+	for {
+		var list twitterDMEventList
+		if err := callTwitterAPI("GET", "direct_messages/events/list.json", params, nil, &list); err != nil {
+			return nil, fmt.Errorf("error getting dm events: %s", err)
+		}
+		for _, e := range list.Events {
+			if e.id() <= sinceID || e.created().Before(cutoff) {
+				return res, nil
+			}
+			res = append(res, e)
+		}
+		if list.NextCursor == "" {
+			return res, nil
+		}
+		params.Set("cursor", list.NextCursor)
+	}
+}
+
+func extractTweetFromDM(e *twitterDMEvent) (*twitter.Tweet, error) {
+	// Is this a link to a tweet?
+	if entities := e.MessageCreate.MessageData.Entities; entities != nil {
+		for _, urlEntity := range entities.Urls {
+			if r := tweetURLPattern.FindStringSubmatch(urlEntity.ExpandedURL); r != nil {
+				tweetID, err := strconv.ParseInt(r[1], 10, 64)
+				if err != nil {
+					// too long to be a tweet ID
+					log.Printf("skipping tweet url %s with an unparseable tweet ID\n", urlEntity.ExpandedURL)
+					continue
+				}
+				// we don't need to check for errors at this point since it cannot be any other kind of message
+				return lookupTweet(tweetID)
+			}
+		}
+	}
+	// is this a tweet ID?
+	if tweetID, err := strconv.ParseInt(e.text(), 10, 64); err == nil {
+		if tweet, err := lookupTweet(tweetID); err == nil {
+			return tweet, nil
+		}
+	}
+
+	return nil, errors.New("no tweet found in dm")
+}
+
+// parseRestyle returns the style and text of a restyle quick reply.
+func parseRestyle(r *twitterQuickReplyResponse) (*mock.Style, string, bool) {
+	if r == nil || !strings.HasPrefix(r.Metadata, twitterRestylePrefix) {
+		return nil, "", false
+	}
+	parts := strings.SplitN(r.Metadata[len(twitterRestylePrefix):], ":", 2)
+	if len(parts) != 2 {
+		return nil, "", false
+	}
+	style, ok := mock.LookupStyle(parts[0])
+	return style, parts[1], ok
+}
+
+// restyleQuickReply offers to mock text again in each of the other styles.
+func restyleQuickReply(text string, current *mock.Style) *twitterQuickReply {
+	qr := &twitterQuickReply{Type: "options"}
+	for _, style := range mock.Styles() {
+		if style == current {
+			continue
+		}
+		metadata := twitterRestylePrefix + style.Name + ":" + text
+		if len(metadata) > twitterQuickReplyMetadataLen {
+			// the text is too long to mock again
+			return nil
+		}
+		qr.Options = append(qr.Options, twitterQuickReplyOption{
+			Label:       "#" + style.Name,
+			Description: "Mock it in the " + style.Name + " style",
+			Metadata:    metadata,
+		})
+	}
+	return qr
+}
+
+// mockDM mocks the text of a DM, replying with the meme attached and quick
+// replies to mock it again in other styles.
+func mockDM(e *twitterDMEvent, ch chan<- error) twitterDMMessageData {
+	style, template, text := extractOptions(e.text())
+	if s, t, ok := parseRestyle(e.MessageCreate.MessageData.QuickReplyResponse); ok {
+		style, text = s, t
+	}
+	mockedText := twitterMocker.WithStyle(style).MockSeed(text, e.id())
+	data := twitterDMMessageData{
+		Text:       mockedText,
+		QuickReply: restyleQuickReply(text, style),
+	}
+	if DEBUG {
+		return data
+	}
+	_, mediaID, err := uploadImage(mockedText, template, twitterDMImage)
+	if err != nil {
+		// the mocked text can still be sent without the meme
+		ch <- fmt.Errorf("upload dm image error: %s", err)
+		return data
+	}
+	data.Attachment = &twitterDMAttachment{Type: "media"}
+	data.Attachment.Media.ID = mediaID
+	return data
+}
+
+// handleDM replies to a DM sent to the bot. It returns an error if the reply
+// couldn't be sent, so that the DM can be tried again.
+func handleDM(e *twitterDMEvent, ch chan<- error) error {
+	logMessageStruct(e, "DM")
+	if e.Type != "message_create" || e.recipientID() != twitterUserID {
+		// don't react these events
+		return nil
+	}
+
+	if tweet, err := extractTweetFromDM(e); err != nil {
+		if e.senderID() != twitterUserID {
+			var response twitterDMMessageData
+			switch strings.ToLower(strings.TrimSpace(e.text())) {
+			case "styles":
+				response.Text = twitterStyleList()
+			case "templates":
+				response.Text = twitterTemplateList()
+			default:
+				// no tweet found, just mock the user dm'ing the bot
+				response = mockDM(e, ch)
+			}
+			if DEBUG {
+				log.Printf("dm'ing back: %+v\n", response)
+			} else {
+				_, err := sendDM(e.senderID(), response)
+				if err != nil {
+					ch <- err
+					return err
+				}
+			}
+		} else {
+			log.Println("DM'd self with invalid message", e.text())
+		}
+	} else {
+		if tweet, err := handleTweet(tweet, ch, false); err != nil {
+			// the user is told about the error instead of the DM being
+			// tried again
+			ch <- fmt.Errorf("error handling tweet from dm: %s", err)
+			_, err := sendDM(e.senderID(), twitterDMMessageData{Text: twitterMocker.Mock("An error occurred. Please try again")})
+			if err != nil {
+				ch <- err
+				return err
+			}
+		} else {
+			_, err := sendDM(e.senderID(), twitterDMMessageData{Text: fmt.Sprintf("https://twitter.com/%s/status/%s", twitterUsername, tweet.IDStr)})
+			if err != nil {
+				ch <- err
+				return err
+			}
+		}
+	}
+	return nil
+}
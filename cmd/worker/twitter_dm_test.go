@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+func TestTwitterDMCreated(t *testing.T) {
+	tests := []struct {
+		timestamp string
+		want      time.Time
+	}{
+		{"1516403560557", time.Unix(1516403560, 557*int64(time.Millisecond))},
+		{"", time.Time{}},
+		{"yesterday", time.Time{}},
+	}
+	for _, test := range tests {
+		e := twitterDMEvent{CreatedTimestamp: test.timestamp}
+		if got := e.created(); !got.Equal(test.want) {
+			t.Errorf("created() of %q = %s, want %s", test.timestamp, got, test.want)
+		}
+	}
+}
+
+func TestExtractTweetFromDMSkipsUnparseableIDs(t *testing.T) {
+	var e twitterDMEvent
+	e.MessageCreate.MessageData.Text = "look at this"
+	e.MessageCreate.MessageData.Entities = &twitter.Entities{
+		Urls: []twitter.URLEntity{
+			{ExpandedURL: "https://twitter.com/someone/status/99999999999999999999999"},
+		},
+	}
+	if tweet, err := extractTweetFromDM(&e); err == nil {
+		t.Errorf("extractTweetFromDM = %+v, want an error", tweet)
+	}
+}
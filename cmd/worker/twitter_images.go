@@ -3,68 +3,203 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
 	"mime/multipart"
 	"net/http"
-	"os"
-	"path/filepath"
+	"net/url"
+	"strconv"
 	"time"
+
+	"github.com/rjchee/spongemock/meme"
 )
 
-var (
-	uploadExpireTime time.Time
-	lastMediaID      int64
-	lastMediaIDStr   string
+// media categories, which Twitter uses to decide how media can be used
+const (
+	twitterTweetImage = "tweet_image"
+	twitterDMImage    = "dm_image"
+	twitterTweetGIF   = "tweet_gif"
+	twitterDMGIF      = "dm_gif"
+	twitterTweetVideo = "tweet_video"
+	twitterDMVideo    = "dm_video"
+)
+
+// twitterMediaLimits are the largest media Twitter accepts in each category,
+// in bytes.
+var twitterMediaLimits = map[string]int{
+	twitterTweetImage: 5 << 20,
+	twitterDMImage:    5 << 20,
+	twitterTweetGIF:   15 << 20,
+	twitterDMGIF:      15 << 20,
+	twitterTweetVideo: 512 << 20,
+	twitterDMVideo:    512 << 20,
+}
+
+const (
+	// twitterChunkSize is the size of each piece media is uploaded in.
+	twitterChunkSize = 1 << 20
+	// twitterChunkAttempts is how many times a chunk is tried.
+	twitterChunkAttempts = 3
+	// twitterProcessingTimeout is how long to wait for media to be processed.
+	twitterProcessingTimeout = 2 * time.Minute
 )
 
 const (
-	// how much time to subtract from the media upload time in seconds
-	mediaUploadBuffer        = 10
 	twitterUploadURL         = "https://upload.twitter.com/1.1/media/upload.json"
 	twitterUploadMetadataURL = "https://upload.twitter.com/1.1/media/metadata/create.json"
 )
 
-func uploadImage() (int64, string, bool, error) {
-	if time.Now().Before(uploadExpireTime) {
-		log.Println("retrieving cached values", lastMediaIDStr)
-		return lastMediaID, lastMediaIDStr, true, nil
+// uploadImage renders the mocked text onto the meme template and uploads it
+// as media of the given category.
+func uploadImage(mockedText string, template *meme.Template, category string) (int64, string, error) {
+	img, err := template.Renderer.Render(mockedText)
+	if err != nil {
+		return 0, "", fmt.Errorf("rendering meme error: %s", err)
 	}
+
 	var b bytes.Buffer
-	w := multipart.NewWriter(&b)
-	memeFile, err := os.Open(memePath)
+	if err = meme.Encode(&b, img, meme.PNG); err != nil {
+		return 0, "", fmt.Errorf("encoding meme error: %s", err)
+	}
+	return uploadMedia(b.Bytes(), meme.PNG.ContentType(), category)
+}
+
+// uploadMedia uploads media in chunks, waiting for Twitter to finish
+// processing it if necessary.
+func uploadMedia(media []byte, mediaType, category string) (int64, string, error) {
+	limit, ok := twitterMediaLimits[category]
+	if !ok {
+		return 0, "", fmt.Errorf("unknown media category %s", category)
+	}
+	if len(media) > limit {
+		return 0, "", fmt.Errorf("%s of %d bytes is over the limit of %d bytes", category, len(media), limit)
+	}
+
+	init, err := postUploadCommand(url.Values{
+		"command":        {"INIT"},
+		"total_bytes":    {strconv.Itoa(len(media))},
+		"media_type":     {mediaType},
+		"media_category": {category},
+	})
 	if err != nil {
-		return 0, "", false, fmt.Errorf("opening meme image file error: %s", err)
+		return 0, "", fmt.Errorf("upload INIT error: %s", err)
 	}
-	defer memeFile.Close()
 
-	fw, err := w.CreateFormFile("media", filepath.Base(memePath))
+	for i := 0; i*twitterChunkSize < len(media); i++ {
+		end := (i + 1) * twitterChunkSize
+		if end > len(media) {
+			end = len(media)
+		}
+		if err := appendChunk(init.MediaIDStr, i, media[i*twitterChunkSize:end]); err != nil {
+			return 0, "", fmt.Errorf("upload APPEND error: %s", err)
+		}
+	}
+
+	resp, err := postUploadCommand(url.Values{
+		"command":  {"FINALIZE"},
+		"media_id": {init.MediaIDStr},
+	})
 	if err != nil {
-		return 0, "", false, fmt.Errorf("creating multipart form file header error: %s", err)
+		return 0, "", fmt.Errorf("upload FINALIZE error: %s", err)
+	}
+	if err := waitForProcessing(resp); err != nil {
+		return 0, "", err
 	}
-	if _, err = io.Copy(fw, memeFile); err != nil {
-		return 0, "", false, fmt.Errorf("io copy error: %s", err)
+	return resp.MediaID, resp.MediaIDStr, nil
+}
+
+// appendChunk uploads one chunk of media, retrying it if it fails.
+func appendChunk(mediaID string, index int, chunk []byte) error {
+	var err error
+	for attempt := 0; attempt < twitterChunkAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(1<<uint(attempt-1)) * time.Second)
+		}
+		var retry bool
+		if retry, err = tryAppendChunk(mediaID, index, chunk); err == nil || !retry {
+			return err
+		}
 	}
+	return fmt.Errorf("chunk %d failed after %d attempts: %s", index, twitterChunkAttempts, err)
+}
+
+// tryAppendChunk uploads one chunk of media, returning whether it's worth
+// retrying if it fails.
+func tryAppendChunk(mediaID string, index int, chunk []byte) (bool, error) {
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+	w.WriteField("command", "APPEND")
+	w.WriteField("media_id", mediaID)
+	w.WriteField("segment_index", strconv.Itoa(index))
+	fw, err := w.CreateFormFile("media", "chunk")
+	if err != nil {
+		return false, fmt.Errorf("creating multipart form file header error: %s", err)
+	}
+	fw.Write(chunk)
 	w.Close()
 
 	req, err := http.NewRequest("POST", twitterUploadURL, &b)
 	if err != nil {
-		return 0, "", false, fmt.Errorf("creating POST request error: %s", err)
+		return false, fmt.Errorf("creating POST request error: %s", err)
 	}
 	req.Header.Set("Content-Type", w.FormDataContentType())
 
-	res, err := twitterUploadClient.Do(req)
+	res, err := twitterHTTPClient.Do(req)
 	if err != nil {
-		return 0, "", false, fmt.Errorf("sending POST request error: %s", err)
+		return true, fmt.Errorf("sending POST request error: %s", err)
 	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		// server errors and rate limits are temporary
+		retry := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("chunk %d bad status: %s", index, res.Status)
+	}
+	return false, nil
+}
 
-	id, idStr, err := parseUploadResponse(res)
-	if err != nil {
-		return 0, "", false, err
+// waitForProcessing polls the status of media until Twitter has finished
+// processing it.
+func waitForProcessing(resp *twitterUploadResponse) error {
+	deadline := time.Now().Add(twitterProcessingTimeout)
+	for info := resp.ProcessingInfo; info != nil; info = resp.ProcessingInfo {
+		switch info.State {
+		case "succeeded":
+			return nil
+		case "failed":
+			if info.Error != nil {
+				return fmt.Errorf("media processing failed: %s", info.Error.Message)
+			}
+			return errors.New("media processing failed")
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for media processing")
+		}
+		wait := time.Duration(info.CheckAfterSecs) * time.Second
+		if wait <= 0 {
+			wait = time.Second
+		}
+		time.Sleep(wait)
+
+		res, err := twitterHTTPClient.Get(twitterUploadURL + "?" + url.Values{
+			"command":  {"STATUS"},
+			"media_id": {resp.MediaIDStr},
+		}.Encode())
+		if err != nil {
+			return fmt.Errorf("upload STATUS error: %s", err)
+		}
+		if resp, err = parseUploadResponse(res); err != nil {
+			return fmt.Errorf("upload STATUS error: %s", err)
+		}
 	}
+	return nil
+}
 
-	return id, idStr, false, nil
+func postUploadCommand(values url.Values) (*twitterUploadResponse, error) {
+	res, err := twitterHTTPClient.PostForm(twitterUploadURL, values)
+	if err != nil {
+		return nil, fmt.Errorf("sending POST request error: %s", err)
+	}
+	return parseUploadResponse(res)
 }
 
 type twitterImageData struct {
@@ -73,37 +208,45 @@ type twitterImageData struct {
 	Height    int    `json:"h"`
 }
 
+// twitterProcessingInfo describes the progress of media which Twitter
+// processes after it's uploaded, like GIFs and videos.
+type twitterProcessingInfo struct {
+	State           string `json:"state"`
+	CheckAfterSecs  int    `json:"check_after_secs"`
+	ProgressPercent int    `json:"progress_percent"`
+	Error           *struct {
+		Code    int    `json:"code"`
+		Name    string `json:"name"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
 type twitterUploadResponse struct {
-	MediaID          int64             `json:"media_id"`
-	MediaIDStr       string            `json:"media_id_string"`
-	Size             int               `json:"size"`
-	ExpiresAfterSecs int               `json:"expires_after_secs"`
-	Image            *twitterImageData `json:"image"`
+	MediaID          int64                  `json:"media_id"`
+	MediaIDStr       string                 `json:"media_id_string"`
+	Size             int                    `json:"size"`
+	ExpiresAfterSecs int                    `json:"expires_after_secs"`
+	Image            *twitterImageData      `json:"image"`
+	ProcessingInfo   *twitterProcessingInfo `json:"processing_info"`
 }
 
-func parseUploadResponse(res *http.Response) (int64, string, error) {
+func parseUploadResponse(res *http.Response) (*twitterUploadResponse, error) {
+	defer res.Body.Close()
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		return 0, "", fmt.Errorf("image upload bad status: %s", res.Status)
+		return nil, fmt.Errorf("media upload bad status: %s", res.Status)
 	}
-	defer res.Body.Close()
 
 	var resBuf bytes.Buffer
 	if _, err := resBuf.ReadFrom(res.Body); err != nil {
-		return 0, "", fmt.Errorf("reading from http response body error: %s", err)
+		return nil, fmt.Errorf("reading from http response body error: %s", err)
 	}
 
-	resp := twitterUploadResponse{}
-	if err := json.Unmarshal(resBuf.Bytes(), &resp); err != nil {
-		return 0, "", fmt.Errorf("unmarshalling twitter upload response error: %s", err)
+	resp := &twitterUploadResponse{}
+	if err := json.Unmarshal(resBuf.Bytes(), resp); err != nil {
+		return nil, fmt.Errorf("unmarshalling twitter upload response error: %s", err)
 	}
 
-	if expDur := resp.ExpiresAfterSecs - mediaUploadBuffer; expDur > 0 {
-		log.Println("expire duration:", expDur)
-		uploadExpireTime = time.Now().Add(time.Duration(expDur) * time.Second)
-		lastMediaID = resp.MediaID
-		lastMediaIDStr = resp.MediaIDStr
-	}
-	return resp.MediaID, resp.MediaIDStr, nil
+	return resp, nil
 }
 
 type twitterAltText struct {
@@ -132,7 +275,7 @@ func uploadMetadata(mediaID, text string) error {
 	}
 	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
 
-	res, err := twitterUploadClient.Do(req)
+	res, err := twitterHTTPClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("sending POST request error: %s", err)
 	}
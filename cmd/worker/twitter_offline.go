@@ -4,146 +4,224 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"net/url"
+	"os"
 	"sort"
+	"time"
 
 	"github.com/dghubble/go-twitter/twitter"
 )
 
-func handleOfflineActivity(ch chan<- error) {
+const (
+	// defaultTwitterPollInterval is how often mentions and DMs are checked
+	// for when webhooks aren't used. The mention and DM endpoints allow 15
+	// requests every 15 minutes.
+	defaultTwitterPollInterval = 2 * time.Minute
+	// maxTwitterMentionAttempts is how many times replying to a mention is
+	// tried before it's skipped.
+	maxTwitterMentionAttempts = 3
+)
+
+var (
+	// twitterSinceIDs keeps the newest handled IDs when there's no database
+	// to store them in.
+	twitterSinceIDs = make(map[string]int64)
+	// twitterMentionFailures counts the failed attempts at replying to each
+	// mention which hasn't been handled yet.
+	twitterMentionFailures = make(map[int64]int)
+)
+
+// twitterPollInterval returns the interval set by $TWITTER_POLL_INTERVAL.
+func twitterPollInterval() (time.Duration, error) {
+	v := os.Getenv("TWITTER_POLL_INTERVAL")
+	if v == "" {
+		return defaultTwitterPollInterval, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid $TWITTER_POLL_INTERVAL %s: %s", v, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid $TWITTER_POLL_INTERVAL %s: must be positive", v)
+	}
+	return d, nil
+}
+
+// pollTwitterActivity keeps catching up on mentions and DMs every interval,
+// for environments where webhooks can't reach the worker.
+func pollTwitterActivity(interval time.Duration, ch chan<- error) {
+	log.Println("polling twitter every", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		handleOfflineTweets(ch, false)
+		handleOfflineDMs(ch, false)
+	}
+}
+
+// handleOfflineActivity catches up on the mentions and DMs since the last
+// catch up. With webhooks, anything still in the webhook queue is left to it.
+func handleOfflineActivity(ch chan<- error, webhooks bool) {
 	err := ensureTimelineTableExists()
 	if err != nil {
 		ch <- err
 		return
 	}
-	handleOfflineTweets(ch)
-	handleOfflineDMs(ch)
+	handleOfflineTweets(ch, webhooks)
+	handleOfflineDMs(ch, webhooks)
 }
 
-func handleOfflineTweets(ch chan<- error) {
+func handleOfflineTweets(ch chan<- error, webhooks bool) {
 	id, err := queryLastID("mentions")
 	if err != nil {
 		ch <- err
 		return
 	}
-	twitterSinceID := id
 
-	mentions := getMentionTimelineStream(id, ch)
-	// make the done channel non-blocking
-	done := make(chan struct{}, 2)
-	defer close(done)
-	tweets := getUserTimelineStream(id, ch, done)
+	// if either timeline is incomplete, it's safer to try again next time
+	// than to reply twice or skip mentions
+	tweets, err := getUserTimeline(id)
+	if err != nil {
+		ch <- err
+		return
+	}
+	mentions, err := getMentionTimeline(id)
+	if err != nil {
+		ch <- err
+		return
+	}
+	var queued map[int64]bool
+	if webhooks {
+		// the queue is read after the timeline so that nothing can be
+		// queued in between and handled twice
+		if queued, _, err = queuedTwitterActivityIDs(); err != nil {
+			ch <- err
+			return
+		}
+	}
 
-	var lastTweetID int64
-	lastTweet, open := <-tweets
-	if open {
-		lastTweetID = lastTweet.InReplyToStatusID
-		if lastTweetID > twitterSinceID {
-			// after responding to all previous tweets, newly added tweets can
-			// only be later than this id
-			twitterSinceID = lastTweetID
+	// find the mentions the bot has replied to since the last catch up
+	replied := make(map[int64]bool)
+	for _, tweet := range tweets {
+		if tweet.InReplyToStatusID != 0 {
+			replied[tweet.InReplyToStatusID] = true
 		}
 	}
 
-	for mention := range mentions {
-		for open && (lastTweetID > mention.ID || lastTweetID == 0) {
-			lastTweet, open = <-tweets
-			if open {
-				lastTweetID = lastTweet.InReplyToStatusID
-				if lastTweetID > twitterSinceID {
-					twitterSinceID = lastTweetID
-				}
+	// handle the oldest mentions first, only moving past a mention once
+	// it's handled so that it's retried if it fails
+	sort.Slice(mentions, func(i, j int) bool {
+		return mentions[i].ID < mentions[j].ID
+	})
+	sinceID := id
+	for i := range mentions {
+		mention := &mentions[i]
+		if !replied[mention.ID] && !queued[mention.ID] {
+			if _, err := handleTweet(mention, ch, true); err != nil && !giveUpOnMention(mention.ID, err) {
+				break
 			}
 		}
-		if lastTweetID != 0 && lastTweetID != mention.ID {
-			// mention hasn't been responded to
-			handleTweet(&mention, ch, true)
-		}
-	}
-	// close the user timeline stream if necessary
-	if open {
-		// send done message to the tweet channel if it's not done
-		done <- struct{}{}
-		// unblock the user timeline channel
-		<-tweets
+		delete(twitterMentionFailures, mention.ID)
+		sinceID = mention.ID
 	}
 
 	if DEBUG {
-		log.Println("twitterSinceID:", twitterSinceID)
-	} else {
-		// store next id in db
-		if err := updateLastID(id == 0, "mentions", twitterSinceID); err != nil {
-			ch <- err
-			return
-		}
+		log.Println("twitterSinceID:", sinceID)
 	}
+	if err := updateLastID("mentions", sinceID); err != nil {
+		ch <- err
+	}
+}
+
+// giveUpOnMention reports whether a mention which couldn't be handled should
+// be skipped rather than retried. Mentions the bot can never reply to are
+// skipped right away, and the rest after maxTwitterMentionAttempts tries.
+func giveUpOnMention(id int64, err error) bool {
+	if !canRetryTweet(err) {
+		return true
+	}
+	twitterMentionFailures[id]++
+	if twitterMentionFailures[id] < maxTwitterMentionAttempts {
+		return false
+	}
+	log.Printf("giving up on mention %d after %d attempts\n", id, twitterMentionFailures[id])
+	return true
 }
 
-type byID []twitter.DirectMessage
+type byID []twitterDMEvent
 
 func (a byID) Len() int           { return len(a) }
 func (a byID) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a byID) Less(i, j int) bool { return a[i].ID < a[j].ID }
+func (a byID) Less(i, j int) bool { return a[i].id() < a[j].id() }
 
-func handleOfflineDMs(ch chan<- error) {
+func handleOfflineDMs(ch chan<- error, webhooks bool) {
 	id, err := queryLastID("direct_messages")
 	if err != nil {
 		ch <- err
 		return
 	}
-	rcvd, d1 := getReceivedDMStream(id, ch)
-	sent, d2 := getSentDMStream(id, ch)
 
-	conversations := make(map[int64][]twitter.DirectMessage)
-	rcvdDone, sentDone := false, false
-	for !rcvdDone || !sentDone {
-		select {
-		case rcv, ok := <-rcvd:
-			if ok && rcv.SenderID != rcv.RecipientID {
-				conversations[rcv.SenderID] = append(conversations[rcv.SenderID], rcv)
-			}
-		case sentDM, ok := <-sent:
-			if ok && sentDM.SenderID != sentDM.RecipientID {
-				conversations[sentDM.RecipientID] = append(conversations[sentDM.RecipientID], sentDM)
-			}
-		case <-d1:
-			rcvdDone = true
-		case <-d2:
-			sentDone = true
+	events, err := getDMEvents(id)
+	if err != nil {
+		// without every DM since the last catch up, some would be missed
+		ch <- err
+		return
+	}
+	var queued map[int64]bool
+	if webhooks {
+		if _, queued, err = queuedTwitterActivityIDs(); err != nil {
+			ch <- err
+			return
+		}
+	}
+
+	// group the DMs by the user the bot is talking to
+	conversations := make(map[string][]twitterDMEvent)
+	for _, e := range events {
+		if e.Type != "message_create" || e.senderID() == e.recipientID() {
+			continue
+		}
+		userID := e.senderID()
+		if userID == twitterUserID {
+			userID = e.recipientID()
 		}
+		conversations[userID] = append(conversations[userID], e)
 	}
 	var latestDMID int64 = id
 	for userID := range conversations {
 		sort.Sort(byID(conversations[userID]))
 		convo := conversations[userID]
-		if latestID := convo[len(convo)-1].ID; latestID > latestDMID {
+		if latestID := convo[len(convo)-1].id(); latestID > latestDMID {
 			latestDMID = latestID
 		}
 
 		// reply to all messages after latest dm sent by bot
 		var i int
 		for i = len(convo) - 1; i >= 0; i-- {
-			if convo[i].SenderScreenName == twitterUsername {
+			if convo[i].senderID() == twitterUserID {
 				break
 			}
 		}
 		convo = convo[i+1:]
-		for _, dm := range convo {
-			handleDM(&dm, ch)
+		for i := range convo {
+			if !queued[convo[i].id()] {
+				handleDM(&convo[i], ch)
+			}
 		}
 	}
 
 	if DEBUG {
 		log.Println("latestDMID:", latestDMID)
-	} else {
-		// store next id in db
-		if err := updateLastID(id == 0, "direct_messages", latestDMID); err != nil {
-			ch <- err
-			return
-		}
+	}
+	if err := updateLastID("direct_messages", latestDMID); err != nil {
+		ch <- err
 	}
 }
 
 func ensureTimelineTableExists() error {
+	if DB == nil {
+		return nil
+	}
 	row := DB.QueryRow("SELECT EXISTS(SELECT * FROM information_schema.tables WHERE table_name=$1);", "tw_timeline_ids")
 	var tableExists bool
 	err := row.Scan(&tableExists)
@@ -160,9 +238,8 @@ func ensureTimelineTableExists() error {
 }
 
 func queryLastID(key string) (int64, error) {
-	if DB == nil {
-		// query from the start of time
-		return 0, nil
+	if id, ok := twitterSinceIDs[key]; ok || DB == nil {
+		return id, nil
 	}
 	row := DB.QueryRow("SELECT tid FROM tw_timeline_ids WHERE name=$1", key)
 	var id int64
@@ -176,145 +253,94 @@ func queryLastID(key string) (int64, error) {
 	return id, nil
 }
 
-func updateLastID(insert bool, key string, lastID int64) error {
-	if insert {
-		_, err := DB.Exec("INSERT INTO tw_timeline_ids (name, tid) VALUES ($1, $2);", key, lastID)
-		if err != nil {
-			return fmt.Errorf("error inserting since id into db: %s", err)
-		}
-	} else {
-		_, err := DB.Exec("UPDATE tw_timeline_ids SET tid=$1 WHERE name=$2", lastID, key)
-		if err != nil {
-			return fmt.Errorf("error updating db: %s", err)
-		}
+// seedTwitterSinceIDs starts the since IDs at the newest mention and DM when
+// there's no database to remember them in, so a restart only handles what
+// arrives afterwards instead of replying to everything Twitter still has.
+func seedTwitterSinceIDs() error {
+	if DB != nil {
+		return nil
+	}
+	mentions, resp, err := twitterAPIClient.Timelines.MentionTimeline(&twitter.MentionTimelineParams{
+		Count:    1,
+		TrimUser: twitter.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("error getting newest mention: %s", err)
+	}
+	resp.Body.Close()
+	if len(mentions) > 0 {
+		twitterSinceIDs["mentions"] = mentions[0].ID
 	}
-	return nil
-}
-
-func getUserTimelineStream(sinceID int64, ch chan<- error, done <-chan struct{}) <-chan twitter.Tweet {
-	tweetCh := make(chan twitter.Tweet, 20)
-	go func() {
-		defer close(tweetCh)
-		params := twitter.UserTimelineParams{
-			ScreenName:      twitterUsername,
-			SinceID:         sinceID,
-			TrimUser:        twitter.Bool(true),
-			ExcludeReplies:  twitter.Bool(false),
-			IncludeRetweets: twitter.Bool(false),
-			TweetMode:       "extended",
-		}
 
-		for {
-			tweets, resp, err := twitterAPIClient.Timelines.UserTimeline(&params)
-			if err != nil {
-				ch <- fmt.Errorf("error getting user timeline: %s", err)
-				return
-			}
-			resp.Body.Close()
-			if len(tweets) == 0 {
-				return
-			}
-			for _, tweet := range tweets {
-				select {
-				case <-done:
-					return
-				default:
-					tweetCh <- tweet
-				}
-			}
-			params.MaxID = tweets[len(tweets)-1].ID - 1
-		}
-	}()
-	return tweetCh
+	var list twitterDMEventList
+	params := url.Values{"count": {"1"}}
+	if err := callTwitterAPI("GET", "direct_messages/events/list.json", params, nil, &list); err != nil {
+		return fmt.Errorf("error getting newest dm: %s", err)
+	}
+	if len(list.Events) > 0 {
+		twitterSinceIDs["direct_messages"] = list.Events[0].id()
+	}
+	log.Println("no database to store since ids in, so only new mentions and dms will be handled")
+	return nil
 }
 
-func getMentionTimelineStream(sinceID int64, ch chan<- error) <-chan twitter.Tweet {
-	tweetCh := make(chan twitter.Tweet, 20)
-	go func() {
-		defer close(tweetCh)
-		params := twitter.MentionTimelineParams{
-			SinceID:   sinceID,
-			TweetMode: "extended",
-		}
-
-		for {
-			tweets, resp, err := twitterAPIClient.Timelines.MentionTimeline(&params)
-			if err != nil {
-				ch <- fmt.Errorf("error getting mention timeline: %s", err)
-				return
-			}
-			resp.Body.Close()
-			if len(tweets) == 0 {
-				return
-			}
-			for _, tweet := range tweets {
-				tweetCh <- tweet
-			}
-			params.MaxID = tweets[len(tweets)-1].ID - 1
-		}
-	}()
-	return tweetCh
+// updateLastID records the newest handled ID. It's kept in memory, and in
+// the database if there is one and the bot isn't in DEBUG mode.
+func updateLastID(key string, lastID int64) error {
+	twitterSinceIDs[key] = lastID
+	if DB == nil || DEBUG {
+		return nil
+	}
+	_, err := DB.Exec("INSERT INTO tw_timeline_ids (name, tid) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET tid=EXCLUDED.tid;", key, lastID)
+	if err != nil {
+		return fmt.Errorf("error updating since id in db: %s", err)
+	}
+	return nil
 }
 
-func getReceivedDMStream(sinceID int64, ch chan<- error) (<-chan twitter.DirectMessage, <-chan struct{}) {
-	dmCh := make(chan twitter.DirectMessage)
-	done := make(chan struct{})
-	go func() {
-		defer close(done)
-		defer func() { done <- struct{}{} }()
-		defer close(dmCh)
-		params := &twitter.DirectMessageGetParams{
-			SinceID:         sinceID,
-			Count:           200,
-			IncludeEntities: twitter.Bool(true),
-			SkipStatus:      twitter.Bool(false),
+// getUserTimeline returns the bot's tweets after sinceID, newest first.
+func getUserTimeline(sinceID int64) ([]twitter.Tweet, error) {
+	params := twitter.UserTimelineParams{
+		ScreenName:      twitterUsername,
+		SinceID:         sinceID,
+		TrimUser:        twitter.Bool(true),
+		ExcludeReplies:  twitter.Bool(false),
+		IncludeRetweets: twitter.Bool(false),
+		TweetMode:       "extended",
+	}
+	var res []twitter.Tweet
+	for {
+		tweets, resp, err := twitterAPIClient.Timelines.UserTimeline(&params)
+		if err != nil {
+			return nil, fmt.Errorf("error getting user timeline: %s", err)
 		}
-		for {
-			dms, resp, err := twitterAPIClient.DirectMessages.Get(params)
-			if err != nil {
-				ch <- err
-				return
-			}
-			resp.Body.Close()
-			if len(dms) == 0 {
-				return
-			}
-			for _, dm := range dms {
-				dmCh <- dm
-			}
-			params.MaxID = dms[len(dms)-1].ID - 1
+		resp.Body.Close()
+		if len(tweets) == 0 {
+			return res, nil
 		}
-	}()
-	return dmCh, done
+		res = append(res, tweets...)
+		params.MaxID = tweets[len(tweets)-1].ID - 1
+	}
 }
 
-func getSentDMStream(sinceID int64, ch chan<- error) (<-chan twitter.DirectMessage, <-chan struct{}) {
-	dmCh := make(chan twitter.DirectMessage)
-	done := make(chan struct{})
-	go func() {
-		defer close(done)
-		defer func() { done <- struct{}{} }()
-		defer close(dmCh)
-		params := &twitter.DirectMessageSentParams{
-			SinceID:         sinceID,
-			Count:           200,
-			IncludeEntities: twitter.Bool(true),
+// getMentionTimeline returns the tweets mentioning the bot after sinceID,
+// newest first.
+func getMentionTimeline(sinceID int64) ([]twitter.Tweet, error) {
+	params := twitter.MentionTimelineParams{
+		SinceID:   sinceID,
+		TweetMode: "extended",
+	}
+	var res []twitter.Tweet
+	for {
+		tweets, resp, err := twitterAPIClient.Timelines.MentionTimeline(&params)
+		if err != nil {
+			return nil, fmt.Errorf("error getting mention timeline: %s", err)
 		}
-		for {
-			dms, resp, err := twitterAPIClient.DirectMessages.Sent(params)
-			if err != nil {
-				ch <- err
-				return
-			}
-			resp.Body.Close()
-			if len(dms) == 0 {
-				return
-			}
-			for _, dm := range dms {
-				dmCh <- dm
-			}
-			params.MaxID = dms[len(dms)-1].ID - 1
+		resp.Body.Close()
+		if len(tweets) == 0 {
+			return res, nil
 		}
-	}()
-	return dmCh, done
+		res = append(res, tweets...)
+		params.MaxID = tweets[len(tweets)-1].ID - 1
+	}
 }
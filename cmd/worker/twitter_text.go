@@ -2,72 +2,262 @@ package main
 
 import (
 	"bytes"
-	"html"
-	"math/rand"
+	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
-	"unicode/utf8"
+
+	"github.com/rjchee/spongemock/meme"
+	"github.com/rjchee/spongemock/mock"
 )
 
 const (
-	maxTweetLen = 280
+	maxTweetLen   = 280
+	twitterURLLen = 23
+	// minTweetTextLen is the least room kept for text when the mentions
+	// replied to would take up most of a tweet.
+	minTweetTextLen = 80
 )
 
 var (
-	twitterTextRegex = regexp.MustCompile("@\\w{1,15}|https://t.co/\\w+|\\s+|.?")
+	twitterMocker       = mock.New(mock.TwitterTokenizer)
+	twitterHashtagRegex = regexp.MustCompile("(?:^|\\s)#(\\w+)")
+	twitterWordRegex    = regexp.MustCompile("\\S+")
+	// twitterEntityRegex matches mentions and URLs, including bare domains
+	// with common top level domains.
+	twitterEntityRegex = regexp.MustCompile("(?i)@\\w{1,15}|(?:https?://|www\\.)\\S+|\\b[a-z0-9-]+(?:\\.[a-z0-9-]+)*\\.(?:com|net|org|edu|gov|io|co|me|ly|tv|gl)\\b(?:/\\S*)?")
+
+	// twitterLightRanges are the code points counted as 1 character, the
+	// rest count as 2.
+	twitterLightRanges = [][2]rune{
+		{0x0000, 0x10FF},
+		{0x2000, 0x200D},
+		{0x2010, 0x201F},
+		{0x2032, 0x2037},
+	}
 )
 
-func transformTwitterText(t string) string {
-	t = html.UnescapeString(t)
+// extractOptions looks for hashtags naming a mock style, like #clap, or a
+// meme template, like #thumbsup, and returns them along with the text without
+// those hashtags. Without a template hashtag the default template is used,
+// and without a style hashtag the template's style is used.
+func extractOptions(text string) (*mock.Style, *meme.Template, string) {
+	var style *mock.Style
+	var template *meme.Template
 	var buffer bytes.Buffer
-	letters := twitterTextRegex.FindAllString(t, -1)
-	trFuncs := []func(string) string{
-		strings.ToUpper,
-		strings.ToLower,
-	}
-	idx := rand.Intn(2)
-	groupSize := rand.Intn(2) + 1
-	for _, ch := range letters {
-		// ignore twitter usernames and URLs
-		if len([]rune(ch)) == 1 && strings.TrimSpace(ch) != "" {
-			ch = trFuncs[idx](ch)
-			groupSize--
-			if groupSize == 0 {
-				idx = (idx + 1) % 2
-				groupSize = 1
-				if rand.Float64() > groupThreshold {
-					groupSize++
-				}
-			}
+	last := 0
+	for _, m := range twitterHashtagRegex.FindAllStringSubmatchIndex(text, -1) {
+		tag := text[m[2]:m[3]]
+		if s, ok := mock.LookupStyle(tag); ok && style == nil {
+			style = s
+		} else if t, ok := twitterMemeTemplates.Lookup(tag); ok && template == nil {
+			template = t
+		} else {
+			continue
 		}
-		buffer.WriteString(ch)
+		buffer.WriteString(text[last:m[0]])
+		last = m[3]
+	}
+	buffer.WriteString(text[last:])
+	text = strings.TrimSpace(buffer.String())
+
+	if template == nil {
+		template = twitterMemeTemplates.Default()
+	}
+	if style == nil {
+		style, _ = mock.LookupStyle(template.Style)
 	}
+	if style == nil {
+		style = mock.Spongebob
+	}
+	return style, template, text
+}
 
-	return buffer.String()
+func twitterStyleList() string {
+	var names []string
+	for _, style := range mock.Styles() {
+		names = append(names, "#"+style.Name)
+	}
+	return "Add one of these hashtags to pick a style: " + strings.Join(names, " ")
+}
+
+func twitterTemplateList() string {
+	var names []string
+	for _, t := range twitterMemeTemplates.Templates() {
+		names = append(names, "#"+t.Name)
+	}
+	return "Add one of these hashtags to pick a meme: " + strings.Join(names, " ")
+}
+
+// tweetLength returns the length of text as Twitter counts it. Following
+// twitter-text, URLs count as twitterURLLen, emoji count as 2 however many
+// code points they're made of, and other characters count as 1 if they're in
+// one of twitterLightRanges and 2 otherwise, so most CJK characters count as
+// 2. Unlike twitter-text, text isn't normalized first.
+func tweetLength(text string) int {
+	var n int
+	for _, u := range tweetUnits(text) {
+		n += u.weight
+	}
+	return n
 }
 
 func tweetTooLong(tweet string) bool {
-	return utf8.RuneCountInString(tweet) > maxTweetLen
+	return tweetLength(tweet) > maxTweetLen
+}
+
+// tweetUnit is a piece of text which can't be split across tweets.
+type tweetUnit struct {
+	text   string
+	weight int
+}
+
+// tweetUnits splits text into URLs, mentions and user perceived characters,
+// so an emoji sequence or a letter with combining marks is one unit.
+func tweetUnits(text string) []tweetUnit {
+	var units []tweetUnit
+	last := 0
+	for _, m := range twitterEntityRegex.FindAllStringIndex(text, -1) {
+		units = appendTweetChars(units, text[last:m[0]])
+		weight := twitterURLLen
+		if text[m[0]] == '@' {
+			// usernames are ASCII
+			weight = m[1] - m[0]
+		}
+		units = append(units, tweetUnit{text[m[0]:m[1]], weight})
+		last = m[1]
+	}
+	return appendTweetChars(units, text[last:])
+}
+
+func appendTweetChars(units []tweetUnit, text string) []tweetUnit {
+	for _, g := range mock.Graphemes(text) {
+		units = append(units, tweetUnit{g, graphemeWeight(g)})
+	}
+	return units
+}
+
+// graphemeWeight returns the weight of the user perceived character g.
+func graphemeWeight(g string) int {
+	if mock.IsEmoji(g) {
+		return 2
+	}
+	var weight int
+	for _, r := range g {
+		weight += runeWeight(r)
+	}
+	return weight
 }
 
-func finalizeTweet(mentions []string, text string) []string {
-	var tweets []string
-	tweet := strings.Join(append(mentions, transformTwitterText(text)), " ")
-	if tweetTooLong(tweet) {
-		tweets = append(tweets, string([]rune(tweet)[:maxTweetLen]))
-		mentions = append([]string{"@" + twitterUsername}, mentions...)
-		for {
-			tweet = strings.Join(append(mentions, string([]rune(tweet)[maxTweetLen:])), " ")
-			if tweetTooLong(tweet) {
-				tweets = append(tweets, string([]rune(tweet)[:maxTweetLen]))
-			} else {
-				tweets = append(tweets, tweet)
-				break
+func runeWeight(r rune) int {
+	for _, lr := range twitterLightRanges {
+		if lr[0] <= r && r <= lr[1] {
+			return 1
+		}
+	}
+	return 2
+}
+
+// finalizeTweet returns the tweets replying with mockedText to the mentioned
+// users. Text too long for one tweet is split between words into a thread of
+// numbered tweets, like "1/3".
+func finalizeTweet(mentions []string, mockedText string) []string {
+	mockedText = strings.TrimSpace(mockedText)
+	if tweet := joinTweet(mentions, mockedText); !tweetTooLong(tweet) {
+		return []string{tweet}
+	}
+
+	// the rest of the thread replies to the bot's previous tweet
+	rest := append([]string{"@" + twitterUsername}, mentions...)
+	for digits := 1; ; digits++ {
+		// room for the widest numbering, like " 10/10"
+		reserve := 2 + 2*digits
+		first := fitMentions(mentions, reserve)
+		others := fitMentions(rest, reserve)
+		parts := splitTweetText(mockedText, func(i int) int {
+			if i == 0 {
+				return maxTweetLen - reserve - tweetLength(joinTweet(first, ""))
+			}
+			return maxTweetLen - reserve - tweetLength(joinTweet(others, ""))
+		})
+		if len(parts) == 1 {
+			// dropping mentions made room for the whole text
+			return []string{joinTweet(first, parts[0])}
+		} else if len(strconv.Itoa(len(parts))) > digits {
+			continue
+		}
+		tweets := make([]string, len(parts))
+		for i, part := range parts {
+			m := others
+			if i == 0 {
+				m = first
 			}
+			tweets[i] = joinTweet(m, fmt.Sprintf("%s %d/%d", part, i+1, len(parts)))
 		}
-	} else {
-		tweets = append(tweets, tweet)
+		return tweets
 	}
+}
+
+func joinTweet(mentions []string, text string) string {
+	if len(mentions) == 0 {
+		return text
+	}
+	return strings.Join(mentions, " ") + " " + text
+}
 
-	return tweets
+// fitMentions drops mentions from the end until there's room for at least
+// minTweetTextLen of text and reserve more. The first mention is always kept.
+func fitMentions(mentions []string, reserve int) []string {
+	for len(mentions) > 1 && tweetLength(joinTweet(mentions, "")) > maxTweetLen-minTweetTextLen-reserve {
+		mentions = mentions[:len(mentions)-1]
+	}
+	return mentions
+}
+
+// splitTweetText splits text into parts no longer than budget(i) for the ith
+// part. Text is split between words where possible, and otherwise between
+// tweetUnits, so URLs, mentions and emoji are never split.
+func splitTweetText(text string, budget func(int) int) []string {
+	var parts []string
+	var part bytes.Buffer
+	var length int
+	flush := func() {
+		if part.Len() > 0 {
+			parts = append(parts, part.String())
+		}
+		part.Reset()
+		length = 0
+	}
+
+	last := 0
+	for _, m := range twitterWordRegex.FindAllStringIndex(text, -1) {
+		space, word := text[last:m[0]], text[m[0]:m[1]]
+		last = m[1]
+		wordLen := tweetLength(word)
+		if part.Len() > 0 {
+			if l := length + tweetLength(space) + wordLen; l <= budget(len(parts)) {
+				part.WriteString(space)
+				part.WriteString(word)
+				length = l
+				continue
+			}
+			flush()
+		}
+		if wordLen <= budget(len(parts)) {
+			part.WriteString(word)
+			length = wordLen
+			continue
+		}
+
+		// the word is too long for a tweet of its own
+		for _, u := range tweetUnits(word) {
+			if length+u.weight > budget(len(parts)) {
+				flush()
+			}
+			part.WriteString(u.text)
+			length += u.weight
+		}
+	}
+	flush()
+	return parts
 }
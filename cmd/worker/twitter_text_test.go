@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTweetLength(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"", 0},
+		{"hello", 5},
+		{"caf\u00e9", 4},
+		// not normalized, so the combining accent counts too
+		{"cafe\u0301", 5},
+		{"日本語", 6},
+		{"👍", 2},
+		{"👍🏽", 2},
+		{"👩‍👩‍👧", 2},
+		{"🇨🇦🇺🇸", 4},
+		{"1️⃣", 2},
+		{"see https://example.com/a/very/long/path/indeed", 27},
+		{"example.com", 23},
+		{"@spongemock_bot hi", 18},
+	}
+	for _, tt := range tests {
+		if got := tweetLength(tt.in); got != tt.want {
+			t.Errorf("tweetLength(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSplitTweetText(t *testing.T) {
+	budget := func(n int) func(int) int {
+		return func(int) int { return n }
+	}
+	tests := []struct {
+		in     string
+		budget int
+		want   []string
+	}{
+		{"short", 10, []string{"short"}},
+		{"one two three four", 9, []string{"one two", "three", "four"}},
+		{"  padded   words  ", 20, []string{"padded   words"}},
+		{"abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"👍🏽👍🏽👍🏽", 4, []string{"👍🏽👍🏽", "👍🏽"}},
+		{"日本語", 3, []string{"日", "本", "語"}},
+		{"go https://example.com", 23, []string{"go", "https://example.com"}},
+	}
+	for _, tt := range tests {
+		if got := splitTweetText(tt.in, budget(tt.budget)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitTweetText(%q, %d) = %q, want %q", tt.in, tt.budget, got, tt.want)
+		}
+	}
+}
+
+func TestFinalizeTweet(t *testing.T) {
+	twitterUsername = "spongemock_bot"
+	mentions := []string{"@alice", "@bob"}
+
+	if got, want := finalizeTweet(mentions, " hElLo "), []string{"@alice @bob hElLo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("finalizeTweet = %q, want %q", got, want)
+	}
+
+	text := strings.Repeat("wOrD ", 150)
+	tweets := finalizeTweet(mentions, text)
+	if len(tweets) < 2 {
+		t.Fatalf("finalizeTweet split %d characters into %d tweets", len(text), len(tweets))
+	}
+	var words int
+	for i, tweet := range tweets {
+		if tweetTooLong(tweet) {
+			t.Errorf("tweet %d is %d characters long", i+1, tweetLength(tweet))
+		}
+		prefix := "@alice @bob "
+		if i > 0 {
+			prefix = "@spongemock_bot @alice @bob "
+		}
+		if !strings.HasPrefix(tweet, prefix) {
+			t.Errorf("tweet %d = %q, want it to start with %q", i+1, tweet, prefix)
+		}
+		suffix := fmt.Sprintf(" %d/%d", i+1, len(tweets))
+		if !strings.HasSuffix(tweet, suffix) {
+			t.Errorf("tweet %d = %q, want it to end with %q", i+1, tweet, suffix)
+		}
+		words += strings.Count(tweet, "wOrD")
+	}
+	if words != 150 {
+		t.Errorf("thread has %d words, want 150", words)
+	}
+}
+
+func TestFinalizeTweetDropsMentions(t *testing.T) {
+	twitterUsername = "spongemock_bot"
+	var mentions []string
+	for i := 0; i < 20; i++ {
+		mentions = append(mentions, fmt.Sprintf("@user_number_%02d", i))
+	}
+	tweets := finalizeTweet(mentions, "hElLo")
+	if len(tweets) != 1 {
+		t.Fatalf("finalizeTweet = %q, want one tweet", tweets)
+	}
+	if !strings.HasPrefix(tweets[0], mentions[0]+" ") || !strings.HasSuffix(tweets[0], " hElLo") || tweetTooLong(tweets[0]) {
+		t.Errorf("finalizeTweet = %q, want the first mention and the text in one tweet", tweets[0])
+	}
+}
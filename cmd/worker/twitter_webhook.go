@@ -0,0 +1,213 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"github.com/lib/pq"
+)
+
+const (
+	// twitterActivityChannel is notified by the web process whenever it
+	// queues activity from the Account Activity webhook.
+	twitterActivityChannel = "twitter_activity"
+	// twitterActivityCheckInterval is how often the queue is checked even
+	// without a notification, in case one was missed while reconnecting or
+	// a payload is waiting to be retried.
+	twitterActivityCheckInterval = time.Minute
+	// maxTwitterActivityAttempts is how many times handling a payload is
+	// tried before it's dropped.
+	maxTwitterActivityAttempts = 3
+	// twitterActivityRetryDelay is how long a payload which couldn't be
+	// handled waits before it's tried again.
+	twitterActivityRetryDelay = time.Minute
+)
+
+// twitterActivity is a payload from the Account Activity API. Only the events
+// the bot reacts to are decoded.
+type twitterActivity struct {
+	ForUserID           string           `json:"for_user_id"`
+	TweetCreateEvents   []twitter.Tweet  `json:"tweet_create_events,omitempty"`
+	DirectMessageEvents []twitterDMEvent `json:"direct_message_events,omitempty"`
+}
+
+// receiveTwitterActivity handles the webhook payloads the web process queues
+// in the database, waiting for a notification between batches.
+func receiveTwitterActivity(ch chan<- error) {
+	if DB == nil {
+		ch <- errors.New("database required to receive twitter webhooks")
+		return
+	}
+	listener := pq.NewListener(os.Getenv("DATABASE_URL"), time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("twitter activity listener error: %s\n", err)
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(twitterActivityChannel); err != nil {
+		ch <- fmt.Errorf("error listening for twitter activity: %s", err)
+		return
+	}
+	log.Println("receiving twitter webhooks from the web process")
+
+	// catching up skips what the bot has already replied to, so handle what
+	// was queued while the worker was down first
+	drainTwitterActivity(ch)
+	handleOfflineActivity(ch, true)
+	for {
+		select {
+		case <-listener.Notify:
+		case <-time.After(twitterActivityCheckInterval):
+		}
+		drainTwitterActivity(ch)
+	}
+}
+
+// drainTwitterActivity handles queued payloads until none are ready.
+func drainTwitterActivity(ch chan<- error) {
+	for {
+		handled, err := handleQueuedTwitterActivity(ch)
+		if err != nil {
+			ch <- err
+			return
+		}
+		if !handled {
+			return
+		}
+	}
+}
+
+// handleQueuedTwitterActivity claims the oldest payload which is ready and
+// handles it, reporting whether there was one. The payload stays in the queue
+// until it's handled, so it's handled again if the worker stops part way.
+// Events which fail are put back to be retried, until the payload has been
+// tried maxTwitterActivityAttempts times.
+func handleQueuedTwitterActivity(ch chan<- error) (bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error starting twitter activity transaction: %s", err)
+	}
+	var id int64
+	var payload string
+	var attempts int
+	row := tx.QueryRow("SELECT id, payload, attempts FROM twitter_activity WHERE retry_at <= now() ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED;")
+	switch err := row.Scan(&id, &payload, &attempts); {
+	case err == sql.ErrNoRows:
+		tx.Rollback()
+		return false, nil
+	case err != nil:
+		tx.Rollback()
+		return false, fmt.Errorf("error taking twitter activity: %s", err)
+	}
+
+	var failed *twitterActivity
+	var activity twitterActivity
+	if err := json.Unmarshal([]byte(payload), &activity); err != nil {
+		// trying again won't help
+		ch <- fmt.Errorf("error unmarshalling twitter activity %d: %s", id, err)
+	} else {
+		failed = handleTwitterActivityEvents(&activity, ch)
+	}
+
+	attempts++
+	if failed == nil || attempts >= maxTwitterActivityAttempts {
+		if failed != nil {
+			log.Printf("giving up on twitter activity %d after %d attempts\n", id, attempts)
+		}
+		_, err = tx.Exec("DELETE FROM twitter_activity WHERE id=$1;", id)
+	} else {
+		// only the events which failed are tried again
+		var remaining []byte
+		if remaining, err = json.Marshal(failed); err != nil {
+			tx.Rollback()
+			return true, fmt.Errorf("error marshalling twitter activity %d: %s", id, err)
+		}
+		_, err = tx.Exec("UPDATE twitter_activity SET payload=$2, attempts=$3, retry_at=now() + $4 * interval '1 second' WHERE id=$1;", id, string(remaining), attempts, twitterActivityRetryDelay.Seconds())
+	}
+	if err != nil {
+		tx.Rollback()
+		return true, fmt.Errorf("error updating twitter activity %d: %s", id, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return true, fmt.Errorf("error committing twitter activity %d: %s", id, err)
+	}
+	return true, nil
+}
+
+// handleTwitterActivityEvents handles the events in a payload, and returns
+// the ones which failed and may succeed if they're tried again, or nil if
+// there are none.
+func handleTwitterActivityEvents(activity *twitterActivity, ch chan<- error) *twitterActivity {
+	failed := twitterActivity{ForUserID: activity.ForUserID}
+	for i := range activity.TweetCreateEvents {
+		tweet := &activity.TweetCreateEvents[i]
+		// the bot's own tweets and replies to them show up here too
+		if !mentionsTwitterUser(tweet) {
+			continue
+		}
+		if _, err := handleTweet(tweet, ch, true); canRetryTweet(err) {
+			failed.TweetCreateEvents = append(failed.TweetCreateEvents, *tweet)
+		}
+	}
+	for i := range activity.DirectMessageEvents {
+		if err := handleDM(&activity.DirectMessageEvents[i], ch); err != nil {
+			failed.DirectMessageEvents = append(failed.DirectMessageEvents, activity.DirectMessageEvents[i])
+		}
+	}
+	if len(failed.TweetCreateEvents) == 0 && len(failed.DirectMessageEvents) == 0 {
+		return nil
+	}
+	return &failed
+}
+
+// queuedTwitterActivityIDs returns the IDs of the mentions and DMs waiting in
+// the queue, including the one being handled.
+func queuedTwitterActivityIDs() (map[int64]bool, map[int64]bool, error) {
+	rows, err := DB.Query("SELECT payload FROM twitter_activity;")
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading twitter activity: %s", err)
+	}
+	defer rows.Close()
+	tweets := make(map[int64]bool)
+	dms := make(map[int64]bool)
+	for rows.Next() {
+		var payload string
+		if err := rows.Scan(&payload); err != nil {
+			return nil, nil, fmt.Errorf("error reading twitter activity: %s", err)
+		}
+		var activity twitterActivity
+		if err := json.Unmarshal([]byte(payload), &activity); err != nil {
+			// it will be dropped when it's handled
+			continue
+		}
+		for _, tweet := range activity.TweetCreateEvents {
+			tweets[tweet.ID] = true
+		}
+		for _, e := range activity.DirectMessageEvents {
+			dms[e.id()] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading twitter activity: %s", err)
+	}
+	return tweets, dms, nil
+}
+
+func mentionsTwitterUser(tweet *twitter.Tweet) bool {
+	if tweet.Entities == nil {
+		return false
+	}
+	for _, m := range tweet.Entities.UserMentions {
+		if strings.EqualFold(m.ScreenName, twitterUsername) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/rjchee/spongemock/meme"
+)
+
+func readTwitterActivity(t *testing.T, name string) *twitterActivity {
+	payload, err := ioutil.ReadFile("../twitter-replay/testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var activity twitterActivity
+	if err := json.Unmarshal(payload, &activity); err != nil {
+		t.Fatalf("error unmarshalling %s: %s", name, err)
+	}
+	return &activity
+}
+
+func TestTwitterActivityTweets(t *testing.T) {
+	twitterUsername = "spongemock_bot"
+	activity := readTwitterActivity(t, "tweet_create.json")
+	if len(activity.TweetCreateEvents) != 1 {
+		t.Fatalf("got %d tweets, want 1", len(activity.TweetCreateEvents))
+	}
+	tweet := &activity.TweetCreateEvents[0]
+	if !mentionsTwitterUser(tweet) {
+		t.Error("mention of the bot wasn't recognized")
+	}
+	if got, want := extractText(tweet), "this bot is really useful"; got != want {
+		t.Errorf("extractText = %q, want %q", got, want)
+	}
+
+	twitterUsername = "someone_else"
+	if mentionsTwitterUser(tweet) {
+		t.Error("tweet mentions a user it doesn't mention")
+	}
+}
+
+func TestTwitterActivityDMs(t *testing.T) {
+	activity := readTwitterActivity(t, "direct_message.json")
+	if len(activity.DirectMessageEvents) != 1 {
+		t.Fatalf("got %d dms, want 1", len(activity.DirectMessageEvents))
+	}
+	e := &activity.DirectMessageEvents[0]
+	c, err := meme.LoadCatalog("../../static/templates.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	twitterMemeTemplates = c
+	if e.Type != "message_create" || e.recipientID() != activity.ForUserID || e.senderID() != "2244994945" {
+		t.Errorf("dm event decoded as %+v", e)
+	}
+	if e.id() != 954491830116155396 {
+		t.Errorf("dm id = %d", e.id())
+	}
+	style, _, text := extractOptions(e.text())
+	if style.Name != "clap" || text != "please mock me" {
+		t.Errorf("extractOptions(%q) = %s, %q", e.text(), style.Name, text)
+	}
+}
@@ -0,0 +1,204 @@
+package meme
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font/opentype"
+)
+
+const (
+	// DefaultCatalogPath is the manifest of the templates shipped with the
+	// app, relative to the app's root directory.
+	DefaultCatalogPath = "static/templates.json"
+	// DefaultImagePath is the image of the default template, shown without
+	// a caption when one can't be rendered.
+	DefaultImagePath = "static/spongemock.jpg"
+)
+
+// Template is a named meme image along with how captions are drawn on it.
+type Template struct {
+	Name        string
+	Description string
+	// Style is the name of the mock style used with this template when
+	// none is requested.
+	Style    string
+	Renderer *Renderer
+}
+
+// Catalog is a set of templates loaded from a manifest.
+type Catalog struct {
+	templates map[string]*Template
+	def       *Template
+}
+
+type manifest struct {
+	Default   string             `json:"default"`
+	Templates []manifestTemplate `json:"templates"`
+}
+
+type manifestTemplate struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Image       string       `json:"image"`
+	Box         *manifestBox `json:"box"`
+	Align       string       `json:"align"`
+	Font        string       `json:"font"`
+	FontSize    float64      `json:"font_size"`
+	Fill        string       `json:"fill"`
+	Outline     string       `json:"outline"`
+	Style       string       `json:"style"`
+}
+
+type manifestBox struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// LoadCatalog reads the JSON manifest at path. Image and font paths in the
+// manifest are relative to the directory the manifest is in. If the manifest
+// has no default template, the first template is the default.
+func LoadCatalog(path string) (*Catalog, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading template manifest error: %s", err)
+	}
+	var m manifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, fmt.Errorf("parsing template manifest error: %s", err)
+	}
+	if len(m.Templates) == 0 {
+		return nil, errors.New("template manifest has no templates")
+	}
+
+	dir := filepath.Dir(path)
+	c := &Catalog{templates: make(map[string]*Template)}
+	for _, mt := range m.Templates {
+		t, err := mt.load(dir)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %s", mt.Name, err)
+		}
+		key := strings.ToLower(t.Name)
+		if _, ok := c.templates[key]; ok {
+			return nil, fmt.Errorf("duplicate template %s", t.Name)
+		}
+		c.templates[key] = t
+	}
+
+	if m.Default == "" {
+		m.Default = m.Templates[0].Name
+	}
+	def, ok := c.Lookup(m.Default)
+	if !ok {
+		return nil, fmt.Errorf("unknown default template %s", m.Default)
+	}
+	c.def = def
+	return c, nil
+}
+
+// LoadDefaultCatalog loads the manifest named by $MEME_TEMPLATES, or the one
+// at DefaultCatalogPath if it isn't set.
+func LoadDefaultCatalog() (*Catalog, error) {
+	path := os.Getenv("MEME_TEMPLATES")
+	if path == "" {
+		path = DefaultCatalogPath
+	}
+	return LoadCatalog(path)
+}
+
+func (mt manifestTemplate) load(dir string) (*Template, error) {
+	if mt.Name == "" {
+		return nil, errors.New("missing name")
+	}
+	r, err := NewRenderer(filepath.Join(dir, mt.Image))
+	if err != nil {
+		return nil, err
+	}
+	if mt.Box != nil {
+		r.Box = image.Rect(mt.Box.X, mt.Box.Y, mt.Box.X+mt.Box.Width, mt.Box.Y+mt.Box.Height).Intersect(r.Template.Bounds())
+		if r.Box.Empty() {
+			return nil, errors.New("caption box is outside of the image")
+		}
+	}
+	switch mt.Align {
+	case "", "bottom":
+	case "top":
+		r.Top = true
+	default:
+		return nil, fmt.Errorf("unknown alignment %s", mt.Align)
+	}
+	if mt.Font != "" {
+		raw, err := ioutil.ReadFile(filepath.Join(dir, mt.Font))
+		if err != nil {
+			return nil, fmt.Errorf("reading font error: %s", err)
+		}
+		if r.Font, err = opentype.Parse(raw); err != nil {
+			return nil, fmt.Errorf("parsing font error: %s", err)
+		}
+	}
+	if mt.FontSize > 0 {
+		r.MaxFontSize = mt.FontSize
+	}
+	if mt.Fill != "" {
+		if r.Fill, err = parseColor(mt.Fill); err != nil {
+			return nil, err
+		}
+	}
+	if mt.Outline != "" {
+		if r.Outline, err = parseColor(mt.Outline); err != nil {
+			return nil, err
+		}
+	}
+	return &Template{
+		Name:        mt.Name,
+		Description: mt.Description,
+		Style:       mt.Style,
+		Renderer:    r,
+	}, nil
+}
+
+// parseColor parses colors of the form #rrggbb.
+func parseColor(s string) (color.Color, error) {
+	if len(s) != 7 || s[0] != '#' {
+		return nil, fmt.Errorf("invalid color %s", s)
+	}
+	v, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid color %s", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, nil
+}
+
+// Lookup returns the template with the given name, ignoring case.
+func (c *Catalog) Lookup(name string) (*Template, bool) {
+	t, ok := c.templates[strings.ToLower(name)]
+	return t, ok
+}
+
+// Default returns the template used when none is requested.
+func (c *Catalog) Default() *Template {
+	return c.def
+}
+
+// Templates returns every template in the catalog sorted by name.
+func (c *Catalog) Templates() []*Template {
+	res := make([]*Template, 0, len(c.templates))
+	for _, t := range c.templates {
+		res = append(res, t)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
@@ -0,0 +1,116 @@
+package meme
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadDefaultCatalog(t *testing.T) {
+	c, err := LoadCatalog(filepath.Join("..", DefaultCatalogPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Default().Name != "spongemock" {
+		t.Errorf("default template is %s", c.Default().Name)
+	}
+	templates := c.Templates()
+	if len(templates) < 2 {
+		t.Fatalf("catalog has %d templates", len(templates))
+	}
+	for i, tmpl := range templates {
+		if i > 0 && templates[i-1].Name >= tmpl.Name {
+			t.Errorf("templates aren't sorted: %s before %s", templates[i-1].Name, tmpl.Name)
+		}
+		if found, ok := c.Lookup(tmpl.Name); !ok || found != tmpl {
+			t.Errorf("Lookup(%q) didn't find the template", tmpl.Name)
+		}
+	}
+	if tmpl, ok := c.Lookup("ThumbsUp"); !ok || !tmpl.Renderer.Top || tmpl.Style != "clap" {
+		t.Errorf("Lookup(%q) = %+v, %v", "ThumbsUp", tmpl, ok)
+	}
+	if _, ok := c.Lookup("nonexistent"); ok {
+		t.Errorf("Lookup(%q) succeeded", "nonexistent")
+	}
+}
+
+func TestLoadCatalog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTemplate(t, dir, "a.png", 200, 100)
+
+	tests := []struct {
+		name     string
+		manifest string
+		ok       bool
+	}{
+		{"minimal", `{"templates": [{"name": "a", "image": "a.png"}]}`, true},
+		{"named default", `{"default": "B", "templates": [{"name": "a", "image": "a.png"}, {"name": "b", "image": "a.png"}]}`, true},
+		{"options", `{"templates": [{"name": "a", "image": "a.png", "box": {"x": 10, "y": 10, "width": 50, "height": 20}, "align": "top", "fill": "#ff0000", "outline": "#00ff00", "font_size": 30}]}`, true},
+		{"invalid json", `{"templates": [`, false},
+		{"no templates", `{"templates": []}`, false},
+		{"missing name", `{"templates": [{"image": "a.png"}]}`, false},
+		{"missing image", `{"templates": [{"name": "a", "image": "b.png"}]}`, false},
+		{"duplicate", `{"templates": [{"name": "a", "image": "a.png"}, {"name": "A", "image": "a.png"}]}`, false},
+		{"unknown default", `{"default": "b", "templates": [{"name": "a", "image": "a.png"}]}`, false},
+		{"box outside", `{"templates": [{"name": "a", "image": "a.png", "box": {"x": 300, "y": 0, "width": 10, "height": 10}}]}`, false},
+		{"unknown align", `{"templates": [{"name": "a", "image": "a.png", "align": "middle"}]}`, false},
+		{"invalid color", `{"templates": [{"name": "a", "image": "a.png", "fill": "red"}]}`, false},
+		{"missing font", `{"templates": [{"name": "a", "image": "a.png", "font": "a.ttf"}]}`, false},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, "templates.json")
+		if err := ioutil.WriteFile(path, []byte(tt.manifest), 0644); err != nil {
+			t.Fatal(err)
+		}
+		c, err := LoadCatalog(path)
+		if tt.ok && err != nil {
+			t.Errorf("%s: LoadCatalog failed: %s", tt.name, err)
+		} else if !tt.ok && err == nil {
+			t.Errorf("%s: LoadCatalog succeeded", tt.name)
+		}
+		if err != nil || tt.name != "options" {
+			continue
+		}
+		r := c.Default().Renderer
+		if r.Box != image.Rect(10, 10, 60, 30) || !r.Top || r.MaxFontSize != 30 {
+			t.Errorf("%s: renderer has box %v, top %v and font size %v", tt.name, r.Box, r.Top, r.MaxFontSize)
+		}
+		if r.Fill != (color.RGBA{0xff, 0, 0, 0xff}) || r.Outline != (color.RGBA{0, 0xff, 0, 0xff}) {
+			t.Errorf("%s: renderer has fill %v and outline %v", tt.name, r.Fill, r.Outline)
+		}
+	}
+	if _, err := LoadCatalog(filepath.Join(dir, "nonexistent.json")); err == nil {
+		t.Error("LoadCatalog of a missing manifest succeeded")
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		in   string
+		want color.Color
+		ok   bool
+	}{
+		{"#000000", color.RGBA{0, 0, 0, 0xff}, true},
+		{"#ffffff", color.RGBA{0xff, 0xff, 0xff, 0xff}, true},
+		{"#12aBcD", color.RGBA{0x12, 0xab, 0xcd, 0xff}, true},
+		{"ffffff", nil, false},
+		{"#fff", nil, false},
+		{"#gggggg", nil, false},
+		{"#-12345", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := parseColor(tt.in)
+		if tt.ok && (err != nil || got != tt.want) {
+			t.Errorf("parseColor(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		} else if !tt.ok && err == nil {
+			t.Errorf("parseColor(%q) succeeded", tt.in)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+// Package meme renders mocked text onto the Spongebob meme image.
+package meme
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// DefaultMaxFontSize is the largest font size as a fraction of the
+	// template height.
+	DefaultMaxFontSize = 1.0 / 8
+	// DefaultMinFontSize is the smallest font size in pixels. Text that
+	// doesn't fit at this size is cut off.
+	DefaultMinFontSize = 12
+	// lineSpacing is the height of a line as a multiple of the font size.
+	lineSpacing = 1.15
+	// outlineWidth is the width of the caption outline as a fraction of the
+	// font size.
+	outlineWidth = 1.0 / 16
+	// margin is the distance between the default caption box and the edges
+	// of the template as a fraction of its smaller dimension.
+	margin = 0.03
+)
+
+// Format is an image encoding the renderer can produce.
+type Format int
+
+const (
+	PNG Format = iota
+	JPEG
+)
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	if f == JPEG {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// Extension returns the file extension of the format, including the dot.
+func (f Format) Extension() string {
+	if f == JPEG {
+		return ".jpg"
+	}
+	return ".png"
+}
+
+var defaultFont *opentype.Font
+
+func init() {
+	var err error
+	defaultFont, err = opentype.Parse(gobold.TTF)
+	if err != nil {
+		panic(fmt.Errorf("parsing default meme font: %s", err))
+	}
+}
+
+// Renderer draws Impact style captions, white text with a black outline,
+// onto a template image.
+type Renderer struct {
+	Template image.Image
+	Font     *opentype.Font
+	// Box is the area of the template the caption is drawn in. Lines are
+	// centered horizontally and stacked from the bottom of the box, or from
+	// the top if Top is set.
+	Box     image.Rectangle
+	Top     bool
+	Fill    color.Color
+	Outline color.Color
+	// MaxFontSize and MinFontSize bound the font size in pixels. The largest
+	// size which fits the whole caption in the box is used.
+	MaxFontSize float64
+	MinFontSize float64
+}
+
+// NewRenderer returns a Renderer for the template image at path, using the
+// default font, colors and caption box.
+func NewRenderer(path string) (*Renderer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening meme template error: %s", err)
+	}
+	defer f.Close()
+	tmpl, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("decoding meme template error: %s", err)
+	}
+	b := tmpl.Bounds()
+	inset := int(margin * float64(min(b.Dx(), b.Dy())))
+	return &Renderer{
+		Template:    tmpl,
+		Font:        defaultFont,
+		Box:         b.Inset(inset),
+		Fill:        color.White,
+		Outline:     color.Black,
+		MaxFontSize: DefaultMaxFontSize * float64(b.Dy()),
+		MinFontSize: DefaultMinFontSize,
+	}, nil
+}
+
+// Render returns the template with text drawn on it.
+func (r *Renderer) Render(text string) (image.Image, error) {
+	if r.Template == nil {
+		return nil, errors.New("no meme template")
+	}
+	b := r.Template.Bounds()
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, r.Template, b.Min, draw.Src)
+
+	text = strings.TrimSpace(r.dropMissingGlyphs(text))
+	if text == "" {
+		return dst, nil
+	}
+
+	face, size, lines, err := r.layout(text)
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	lineHeight := fixed.I(int(math.Ceil(size * lineSpacing)))
+	outline := int(math.Max(1, math.Floor(size*outlineWidth+0.5)))
+	descent := face.Metrics().Descent
+
+	d := &font.Drawer{
+		Dst:  dst,
+		Face: face,
+	}
+	var y fixed.Int26_6
+	if r.Top {
+		// the first line hangs just below the top of the box
+		y = fixed.I(r.Box.Min.Y) + face.Metrics().Ascent
+	} else {
+		// the baseline of the last line sits just above the bottom of the box
+		y = fixed.I(r.Box.Max.Y) - descent - lineHeight.Mul(fixed.I(len(lines)-1))
+	}
+	for _, line := range lines {
+		x := fixed.I(r.Box.Min.X) + (fixed.I(r.Box.Dx())-d.MeasureString(line))/2
+		d.Src = image.NewUniform(r.Outline)
+		for dx := -outline; dx <= outline; dx++ {
+			for dy := -outline; dy <= outline; dy++ {
+				if dx*dx+dy*dy > outline*outline {
+					continue
+				}
+				d.Dot = fixed.Point26_6{X: x + fixed.I(dx), Y: y + fixed.I(dy)}
+				d.DrawString(line)
+			}
+		}
+		d.Src = image.NewUniform(r.Fill)
+		d.Dot = fixed.Point26_6{X: x, Y: y}
+		d.DrawString(line)
+		y += lineHeight
+	}
+	return dst, nil
+}
+
+// dropMissingGlyphs removes the characters the font can't draw, such as
+// emoji, rather than drawing placeholder boxes.
+func (r *Renderer) dropMissingGlyphs(text string) string {
+	var buf sfnt.Buffer
+	return strings.Map(func(c rune) rune {
+		if unicode.IsSpace(c) {
+			return c
+		}
+		if i, err := r.Font.GlyphIndex(&buf, c); err != nil || i == 0 {
+			return -1
+		}
+		return c
+	}, text)
+}
+
+// layout finds the largest font size at which text fits in the caption box
+// and returns the face and its size along with the wrapped lines.
+func (r *Renderer) layout(text string) (font.Face, float64, []string, error) {
+	size := r.MaxFontSize
+	for {
+		face, err := opentype.NewFace(r.Font, &opentype.FaceOptions{
+			Size:    size,
+			DPI:     72,
+			Hinting: font.HintingFull,
+		})
+		if err != nil {
+			return nil, 0, nil, fmt.Errorf("creating font face error: %s", err)
+		}
+		lines := wrap(face, text, fixed.I(r.Box.Dx()))
+		height := math.Ceil(size*lineSpacing) * float64(len(lines))
+		if height <= float64(r.Box.Dy()) || size <= r.MinFontSize {
+			return face, size, lines, nil
+		}
+		face.Close()
+		size = math.Max(r.MinFontSize, size*0.9)
+	}
+}
+
+// wrap breaks text into lines no wider than width, breaking between words
+// where possible. Explicit line breaks in the text are kept.
+func wrap(face font.Face, text string, width fixed.Int26_6) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		var line string
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if font.MeasureString(face, candidate) <= width {
+				line = candidate
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+			}
+			// words which are too long for a line on their own are broken
+			// wherever they need to be, leaving the last piece to start the
+			// next line
+			for font.MeasureString(face, word) > width {
+				n := fit(face, word, width)
+				if n == len(word) {
+					break
+				}
+				lines = append(lines, word[:n])
+				word = word[n:]
+			}
+			line = word
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// fit returns the length in bytes of the longest prefix of s that is at most
+// width wide, always including at least one rune.
+func fit(face font.Face, s string, width fixed.Int26_6) int {
+	n := 0
+	for i, r := range s {
+		end := i + utf8.RuneLen(r)
+		if n > 0 && font.MeasureString(face, s[:end]) > width {
+			break
+		}
+		n = end
+	}
+	return n
+}
+
+// Encode writes m to w in the given format.
+func Encode(w io.Writer, m image.Image, f Format) error {
+	switch f {
+	case JPEG:
+		return jpeg.Encode(w, m, &jpeg.Options{Quality: 90})
+	default:
+		return png.Encode(w, m)
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
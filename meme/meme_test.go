@@ -0,0 +1,190 @@
+package meme
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// writeTemplate writes a blank gray image to a PNG file in dir and returns
+// its path.
+func writeTemplate(t *testing.T, dir, name string, width, height int) string {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.Gray{0x80}), image.ZP, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestRenderer(t *testing.T) (*Renderer, func()) {
+	dir, err := ioutil.TempDir("", "meme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRenderer(writeTemplate(t, dir, "template.png", 400, 300))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return r, func() { os.RemoveAll(dir) }
+}
+
+// changedRows returns the first and last rows where m differs from the
+// template, or -1 and -1 if it doesn't.
+func changedRows(r *Renderer, m image.Image) (int, int) {
+	first, last := -1, -1
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if m.At(x, y) != color.RGBAModel.Convert(r.Template.At(x, y)) {
+				if first < 0 {
+					first = y
+				}
+				last = y
+				break
+			}
+		}
+	}
+	return first, last
+}
+
+func TestNewRenderer(t *testing.T) {
+	r, cleanup := newTestRenderer(t)
+	defer cleanup()
+	if got, want := r.Template.Bounds(), image.Rect(0, 0, 400, 300); got != want {
+		t.Errorf("template bounds = %v, want %v", got, want)
+	}
+	if !r.Box.In(r.Template.Bounds()) || r.Box == r.Template.Bounds() {
+		t.Errorf("caption box %v isn't inset in the template", r.Box)
+	}
+	if r.MaxFontSize != DefaultMaxFontSize*300 || r.MinFontSize != DefaultMinFontSize {
+		t.Errorf("font sizes are %v to %v", r.MinFontSize, r.MaxFontSize)
+	}
+	if _, err := NewRenderer("nonexistent.png"); err == nil {
+		t.Error("NewRenderer of a missing image succeeded")
+	}
+}
+
+func TestRender(t *testing.T) {
+	r, cleanup := newTestRenderer(t)
+	defer cleanup()
+	tests := []struct {
+		name string
+		text string
+		top  bool
+		// drawn is whether anything is drawn, and if so whether it's in
+		// the top or the bottom half of the image.
+		drawn bool
+	}{
+		{name: "empty", text: ""},
+		{name: "blank", text: " \n\t "},
+		{name: "emoji only", text: "\U0001F44D"},
+		{name: "bottom", text: "hElLo WoRlD", drawn: true},
+		{name: "top", text: "hElLo WoRlD", top: true, drawn: true},
+		{name: "long", text: strings.Repeat("mOcK ", 100), drawn: true},
+	}
+	for _, tt := range tests {
+		r.Top = tt.top
+		m, err := r.Render(tt.text)
+		if err != nil {
+			t.Errorf("%s: Render failed: %s", tt.name, err)
+			continue
+		}
+		if m.Bounds() != r.Template.Bounds() {
+			t.Errorf("%s: Render bounds = %v, want %v", tt.name, m.Bounds(), r.Template.Bounds())
+		}
+		first, last := changedRows(r, m)
+		switch {
+		case !tt.drawn:
+			if first >= 0 {
+				t.Errorf("%s: Render drew on rows %d to %d", tt.name, first, last)
+			}
+		case first < 0:
+			t.Errorf("%s: Render drew nothing", tt.name)
+		case first < r.Box.Min.Y-2 || last >= r.Box.Max.Y+2:
+			t.Errorf("%s: Render drew on rows %d to %d, outside of the box %v", tt.name, first, last, r.Box)
+		case tt.top && last > r.Box.Dy()/2 && tt.name != "long":
+			t.Errorf("%s: Render drew on rows %d to %d, not at the top", tt.name, first, last)
+		case !tt.top && first < r.Box.Dy()/2 && tt.name != "long":
+			t.Errorf("%s: Render drew on rows %d to %d, not at the bottom", tt.name, first, last)
+		}
+	}
+}
+
+func TestRenderWithoutTemplate(t *testing.T) {
+	if _, err := (&Renderer{}).Render("hi"); err == nil {
+		t.Error("Render without a template succeeded")
+	}
+}
+
+func TestWrap(t *testing.T) {
+	face, err := opentype.NewFace(defaultFont, &opentype.FaceOptions{Size: 20, DPI: 72})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer face.Close()
+	width := font.MeasureString(face, "abcde")
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"abc", []string{"abc"}},
+		{"ab cd", []string{"ab cd"}},
+		{"abc def", []string{"abc", "def"}},
+		{"ab\ncd", []string{"ab", "cd"}},
+		{"abcdefghij", []string{"abcde", "fghij"}},
+		{"  ab  ", []string{"ab"}},
+	}
+	for _, tt := range tests {
+		got := wrap(face, tt.in, width)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("wrap(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		for _, line := range got {
+			if font.MeasureString(face, line) > width {
+				t.Errorf("wrap(%q) line %q is wider than %v", tt.in, line, width)
+			}
+		}
+	}
+	// a line always has at least one character
+	if got := wrap(face, "ab", fixed.I(1)); strings.Join(got, "|") != "a|b" {
+		t.Errorf("wrap(%q) into a tiny width = %q", "ab", got)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for _, f := range []Format{PNG, JPEG} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, f); err != nil {
+			t.Errorf("Encode(%s) failed: %s", f.ContentType(), err)
+			continue
+		}
+		_, format, err := image.Decode(&buf)
+		if err != nil {
+			t.Errorf("decoding %s failed: %s", f.ContentType(), err)
+		} else if "image/"+format != f.ContentType() {
+			t.Errorf("Encode(%s) wrote %s", f.ContentType(), format)
+		}
+	}
+	if PNG.Extension() != ".png" || JPEG.Extension() != ".jpg" {
+		t.Errorf("extensions are %s and %s", PNG.Extension(), JPEG.Extension())
+	}
+}
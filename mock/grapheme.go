@@ -0,0 +1,134 @@
+package mock
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	zwj  = '\u200d'
+	zwnj = '\u200c'
+
+	hangulBase   = 0xAC00
+	hangulEnd    = 0xD7A3
+	hangulTCount = 28
+)
+
+// Graphemes splits s into user perceived characters, following the extended
+// grapheme cluster rules of Unicode Standard Annex #29 closely enough for
+// mocking: combining and spacing marks, variation selectors, emoji modifiers
+// and tags, zero width joiner sequences, regional indicator flags and Hangul
+// syllables are all kept together with the character they belong to.
+func Graphemes(s string) []string {
+	var res []string
+	start := 0
+	var prev rune = -1
+	// number of consecutive regional indicators before the current rune
+	riCount := 0
+	for i, r := range s {
+		if prev >= 0 && isGraphemeBreak(prev, r, riCount) {
+			res = append(res, s[start:i])
+			start = i
+		}
+		if isRegionalIndicator(r) {
+			riCount++
+		} else {
+			riCount = 0
+		}
+		prev = r
+	}
+	if start < len(s) {
+		res = append(res, s[start:])
+	}
+	return res
+}
+
+// IsEmoji reports whether the grapheme g is an emoji, including flags and
+// keycaps like 1️⃣.
+func IsEmoji(g string) bool {
+	r, _ := utf8.DecodeRuneInString(g)
+	switch {
+	case isRegionalIndicator(r):
+		return true
+	case r == '#' || r == '*' || ('0' <= r && r <= '9'):
+		return strings.ContainsRune(g, 0x20E3)
+	}
+	return (r >= 0x1F000 && r <= 0x1FAFF) ||
+		(r >= 0x2300 && r <= 0x23FF) ||
+		(r >= 0x2600 && r <= 0x27BF) ||
+		(r >= 0x2B00 && r <= 0x2BFF) ||
+		r == 0x00A9 || r == 0x00AE || r == 0x203C || r == 0x2049 ||
+		r == 0x2122 || r == 0x2139 || r == 0x3030 || r == 0x303D ||
+		r == 0x3297 || r == 0x3299
+}
+
+func isGraphemeBreak(prev, r rune, riCount int) bool {
+	switch {
+	case prev == '\r' && r == '\n':
+		return false
+	case isControl(prev) || isControl(r):
+		return true
+	case isHangulJoin(prev, r):
+		return false
+	case isExtend(r):
+		return false
+	case prev == zwj && isPictographic(r):
+		return false
+	case isRegionalIndicator(prev) && isRegionalIndicator(r):
+		// flags are pairs of regional indicators
+		return riCount%2 == 0
+	}
+	return true
+}
+
+func isControl(r rune) bool {
+	return r != zwj && r != zwnj && (unicode.IsControl(r) || unicode.In(r, unicode.Zl, unicode.Zp))
+}
+
+// isExtend reports whether r attaches to the preceding character.
+func isExtend(r rune) bool {
+	return r == zwj || r == zwnj ||
+		unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc) ||
+		// emoji skin tone modifiers
+		(r >= 0x1F3FB && r <= 0x1F3FF) ||
+		// emoji tag sequences
+		(r >= 0xE0020 && r <= 0xE007F)
+}
+
+func isPictographic(r rune) bool {
+	return unicode.Is(unicode.So, r) || (r >= 0x1F000 && r <= 0x1FAFF)
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+func isHangulJoin(prev, r rune) bool {
+	l := func(r rune) bool { return r >= 0x1100 && r <= 0x115F }
+	v := func(r rune) bool { return r >= 0x1160 && r <= 0x11A7 }
+	t := func(r rune) bool { return r >= 0x11A8 && r <= 0x11FF }
+	syllable := r >= hangulBase && r <= hangulEnd
+	prevSyllable := prev >= hangulBase && prev <= hangulEnd
+	prevLV := prevSyllable && (prev-hangulBase)%hangulTCount == 0
+	switch {
+	case l(prev):
+		return l(r) || v(r) || syllable
+	case v(prev) || prevLV:
+		return v(r) || t(r)
+	case t(prev) || prevSyllable:
+		return t(r)
+	}
+	return false
+}
+
+// isCased reports whether the grapheme g contains a letter with upper and
+// lower case forms.
+func isCased(g string) bool {
+	for _, r := range g {
+		if r == 'ß' || unicode.ToUpper(r) != r || unicode.ToLower(r) != r {
+			return true
+		}
+	}
+	return false
+}
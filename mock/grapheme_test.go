@@ -0,0 +1,95 @@
+package mock
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpecialCase(t *testing.T) {
+	m := New(PlainTokenizer).WithStyle(Whisper)
+	if got, want := m.Mock("İSTANBUL ığ"), "istanbul ığ"; got != want {
+		t.Errorf("Mock(%q) = %q, want %q", "İSTANBUL ığ", got, want)
+	}
+	m = m.WithStyle(Clap)
+	if got, want := m.Mock("straße"), "STRASSE"; got != want {
+		t.Errorf("Mock(%q) = %q, want %q", "straße", got, want)
+	}
+}
+
+func TestGraphemes(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"abc", []string{"a", "b", "c"}},
+		{"éa", []string{"é", "a"}},
+		{"a\r\nb", []string{"a", "\r\n", "b"}},
+		{"👍🏽!", []string{"👍🏽", "!"}},
+		{"👩‍👩‍👧x", []string{"👩‍👩‍👧", "x"}},
+		{"🇨🇦🇺🇸🇫", []string{"🇨🇦", "🇺🇸", "🇫"}},
+		{"1️⃣", []string{"1️⃣"}},
+		{"🏴\U000e0067\U000e0062\U000e0065\U000e006e\U000e0067\U000e007f", []string{"🏴\U000e0067\U000e0062\U000e0065\U000e006e\U000e0067\U000e007f"}},
+		{"각가", []string{"각", "가"}},
+	}
+	for _, tt := range tests {
+		if got := Graphemes(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Graphemes(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIsEmoji(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"👍", true},
+		{"👍🏽", true},
+		{"👩‍👩‍👧", true},
+		{"🇨🇦", true},
+		{"1️⃣", true},
+		{"☀️", true},
+		{"1", false},
+		{"a", false},
+		{"é", false},
+		{"日", false},
+	}
+	for _, tt := range tests {
+		if got := IsEmoji(tt.in); got != tt.want {
+			t.Errorf("IsEmoji(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMockKeepsGraphemesTogether(t *testing.T) {
+	m := New(PlainTokenizer).WithStyle(Clap)
+	if got, want := m.Mock("café 👍🏽"), "CAFÉ \U0001F44F 👍🏽"; got != want {
+		t.Errorf("Mock = %q, want %q", got, want)
+	}
+}
+
+// tokenTexts returns the text of each token, with the text of tokens which
+
+// aren't mocked wrapped in brackets.
+
+func TestTokensAreCased(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"a", true},
+		{"Z", true},
+		{"ß", true},
+		{"1", false},
+		{"!", false},
+		{"가", false},
+		{"👍", false},
+	}
+	for _, tt := range tests {
+		tokens := PlainTokenizer.Tokenize(tt.in)
+		if len(tokens) != 1 || tokens[0].Cased != tt.want {
+			t.Errorf("PlainTokenizer.Tokenize(%q) = %+v, want one token with Cased = %v", tt.in, tokens, tt.want)
+		}
+	}
+}
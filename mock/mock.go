@@ -0,0 +1,82 @@
+// Package mock implements the Spongebob mocking text transformation shared
+// by every platform spongemock posts to.
+package mock
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"strings"
+	"unicode"
+)
+
+// Mocker transforms text using its Style, using its Tokenizer to decide
+// which parts of the text are left alone.
+type Mocker struct {
+	Tokenizer Tokenizer
+	Style     *Style
+}
+
+// New returns a Mocker using the given tokenizer and the default style.
+func New(t Tokenizer) *Mocker {
+	return &Mocker{
+		Tokenizer: t,
+		Style:     Spongebob,
+	}
+}
+
+// WithStyle returns a copy of m which mocks text using the given style. A nil
+// style leaves the style unchanged.
+func (m *Mocker) WithStyle(s *Style) *Mocker {
+	res := *m
+	if s != nil {
+		res.Style = s
+	}
+	return &res
+}
+
+// globalSource draws from the top level math/rand functions, which are safe
+// for concurrent use.
+type globalSource struct{}
+
+func (globalSource) Int63() int64 { return rand.Int63() }
+func (globalSource) Seed(int64)   {}
+
+// Seed derives a random seed from an identifier such as a message ID, so the
+// same message is always mocked the same way.
+func Seed(id string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return int64(h.Sum64())
+}
+
+// Mock returns the mocked version of s using the global random source.
+func (m *Mocker) Mock(s string) string {
+	return m.MockRand(s, rand.New(globalSource{}))
+}
+
+// MockSeed returns the mocked version of s. The output only depends on s, the
+// style and the seed.
+func (m *Mocker) MockSeed(s string, seed int64) string {
+	return m.MockRand(s, rand.New(rand.NewSource(seed)))
+}
+
+// MockRand returns the mocked version of s, drawing all randomness from r.
+func (m *Mocker) MockRand(s string, r *rand.Rand) string {
+	tokens := m.Tokenizer.Tokenize(s)
+	if special := specialCase(s); special != nil {
+		for i := range tokens {
+			tokens[i].special = special
+		}
+	}
+	return m.Style.Apply(tokens, r)
+}
+
+// specialCase guesses whether s needs language specific case mappings.
+// Turkish and Azeri text is recognized by its letters which don't appear in
+// other Latin alphabets, so dotted and dotless i keep their dots.
+func specialCase(s string) unicode.SpecialCase {
+	if strings.ContainsAny(s, "ıİğĞşŞ") {
+		return unicode.TurkishCase
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package mock
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMockSeedIsDeterministic(t *testing.T) {
+	m := New(PlainTokenizer)
+	text := "the quick brown fox jumps over the lazy dog"
+	for _, style := range Styles() {
+		m := m.WithStyle(style)
+		want := m.MockSeed(text, Seed("C123/1500000000.000100"))
+		for i := 0; i < 5; i++ {
+			if got := m.MockSeed(text, Seed("C123/1500000000.000100")); got != want {
+				t.Errorf("%s: MockSeed(%q) = %q, previously %q", style.Name, text, got, want)
+			}
+		}
+	}
+}
+
+func TestMockSeedDependsOnSeed(t *testing.T) {
+	m := New(PlainTokenizer)
+	text := "the quick brown fox jumps over the lazy dog"
+	if m.MockSeed(text, Seed("a")) == m.MockSeed(text, Seed("b")) {
+		t.Errorf("MockSeed(%q) gave the same output for different seeds", text)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	if Seed("1234") != Seed("1234") {
+		t.Error("Seed gave different seeds for the same ID")
+	}
+	if Seed("1234") == Seed("1235") {
+		t.Error("Seed gave the same seed for different IDs")
+	}
+}
+
+func TestSpongebobKeepsLetters(t *testing.T) {
+	m := New(PlainTokenizer)
+	text := "Hello, world! 123"
+	for seed := int64(0); seed < 20; seed++ {
+		got := m.MockSeed(text, seed)
+		if strings.ToLower(got) != strings.ToLower(text) {
+			t.Errorf("MockSeed(%q, %d) = %q, which changes more than case", text, seed, got)
+		}
+	}
+}
+
+func TestSpongebobGroups(t *testing.T) {
+	m := New(PlainTokenizer)
+	text := strings.Repeat("a", 200)
+	for seed := int64(0); seed < 20; seed++ {
+		got := m.MockSeed(text, seed)
+		// groups of the same case are one or two letters long
+		if strings.Contains(got, "aaa") || strings.Contains(got, "AAA") {
+			t.Errorf("MockSeed(%q, %d) = %q has a group longer than two letters", text, seed, got)
+		}
+	}
+}
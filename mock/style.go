@@ -0,0 +1,209 @@
+package mock
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// Style is a way of mocking text. Apply receives the tokens of the text and
+// returns the mocked text, drawing any randomness from r.
+type Style struct {
+	Name        string
+	Description string
+	Apply       func(tokens []Token, r *rand.Rand) string
+}
+
+const (
+	// DefaultStyle is the name of the style used when none is given.
+	DefaultStyle = "spongebob"
+
+	// groupThreshold is the probability that a group of letters with the
+	// same case in the spongebob style ends after a single letter instead
+	// of two.
+	groupThreshold = 0.8
+)
+
+var (
+	styles = make(map[string]*Style)
+
+	// Spongebob switches between upper and lower case in randomly sized
+	// groups of one or two letters.
+	Spongebob = &Style{
+		Name:        DefaultStyle,
+		Description: "rAndOm UpPEr AnD LOwEr cAsE",
+		Apply:       spongebob,
+	}
+
+	// Alternating strictly alternates between lower and upper case.
+	Alternating = &Style{
+		Name:        "alternating",
+		Description: "sTrIcTlY aLtErNaTiNg CaSe",
+		Apply:       alternating,
+	}
+
+	// Clap shouts every word and puts a clapping emoji between them.
+	Clap = &Style{
+		Name:        "clap",
+		Description: "CLAP \U0001F44F EVERY \U0001F44F WORD",
+		Apply:       clap,
+	}
+
+	// Vaporwave converts text to full width characters.
+	Vaporwave = &Style{
+		Name:        "vaporwave",
+		Description: "ｆｕｌｌ ｗｉｄｔｈ ｔｅｘｔ",
+		Apply: func(tokens []Token, r *rand.Rand) string {
+			return mapTokens(tokens, func(tok Token) string {
+				return fullWidth(tok.Text)
+			})
+		},
+	}
+
+	// SmallCaps converts letters to their small capital forms.
+	SmallCaps = &Style{
+		Name:        "smallcaps",
+		Description: "ꜱᴍᴀʟʟ ᴄᴀᴘɪᴛᴀʟ ʟᴇᴛᴛᴇʀꜱ",
+		Apply: func(tokens []Token, r *rand.Rand) string {
+			return mapTokens(tokens, func(tok Token) string {
+				return smallCapsReplacer.Replace(tok.Lower())
+			})
+		},
+	}
+
+	// Whisper converts everything to lower case.
+	Whisper = &Style{
+		Name:        "whisper",
+		Description: "quiet lower case text",
+		Apply: func(tokens []Token, r *rand.Rand) string {
+			return mapTokens(tokens, Token.Lower)
+		},
+	}
+)
+
+func init() {
+	for _, s := range []*Style{Spongebob, Alternating, Clap, Vaporwave, SmallCaps, Whisper} {
+		RegisterStyle(s)
+	}
+}
+
+// RegisterStyle makes a style available through LookupStyle. Registering a
+// style with the same name as an existing style replaces it.
+func RegisterStyle(s *Style) {
+	styles[strings.ToLower(s.Name)] = s
+}
+
+// LookupStyle returns the registered style with the given name, ignoring
+// case.
+func LookupStyle(name string) (*Style, bool) {
+	s, ok := styles[strings.ToLower(name)]
+	return s, ok
+}
+
+// Styles returns all registered styles sorted by name.
+func Styles() []*Style {
+	res := make([]*Style, 0, len(styles))
+	for _, s := range styles {
+		res = append(res, s)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// mapTokens applies f to every mockable token.
+func mapTokens(tokens []Token, f func(Token) string) string {
+	var buffer bytes.Buffer
+	for _, tok := range tokens {
+		if tok.Mock {
+			buffer.WriteString(f(tok))
+		} else {
+			buffer.WriteString(tok.Text)
+		}
+	}
+	return buffer.String()
+}
+
+func spongebob(tokens []Token, r *rand.Rand) string {
+	trFuncs := []func(Token) string{
+		Token.Upper,
+		Token.Lower,
+	}
+	idx := r.Intn(2)
+	groupSize := r.Intn(2) + 1
+	return mapTokens(tokens, func(tok Token) string {
+		if !tok.Cased {
+			// letters without case don't count towards a group
+			return tok.Text
+		}
+		ch := trFuncs[idx](tok)
+		groupSize--
+		if groupSize == 0 {
+			idx = (idx + 1) % 2
+			groupSize = 1
+			if r.Float64() > groupThreshold {
+				groupSize++
+			}
+		}
+		return ch
+	})
+}
+
+func alternating(tokens []Token, r *rand.Rand) string {
+	n := 0
+	return mapTokens(tokens, func(tok Token) string {
+		if !tok.Cased {
+			return tok.Text
+		}
+		n++
+		if n%2 == 0 {
+			return tok.Upper()
+		}
+		return tok.Lower()
+	})
+}
+
+func clap(tokens []Token, r *rand.Rand) string {
+	// only clap between words, not around the text
+	first, last := 0, len(tokens)
+	for first < last && isSpace(tokens[first].Text) {
+		first++
+	}
+	for last > first && isSpace(tokens[last-1].Text) {
+		last--
+	}
+	var buffer bytes.Buffer
+	for i, tok := range tokens {
+		switch {
+		case tok.Mock:
+			buffer.WriteString(tok.Upper())
+		case i > first && i < last && isSpace(tok.Text) && !strings.Contains(tok.Text, "\n"):
+			buffer.WriteString(" \U0001F44F ")
+		default:
+			buffer.WriteString(tok.Text)
+		}
+	}
+	return buffer.String()
+}
+
+func isSpace(s string) bool {
+	return s != "" && strings.TrimSpace(s) == ""
+}
+
+func fullWidth(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '!' && r <= '~' {
+			return r + 0xFEE0
+		}
+		return r
+	}, s)
+}
+
+var smallCapsReplacer = strings.NewReplacer(
+	"a", "ᴀ", "b", "ʙ", "c", "ᴄ", "d", "ᴅ", "e", "ᴇ", "f", "ꜰ", "g", "ɢ",
+	"h", "ʜ", "i", "ɪ", "j", "ᴊ", "k", "ᴋ", "l", "ʟ", "m", "ᴍ", "n", "ɴ",
+	"o", "ᴏ", "p", "ᴘ", "q", "ǫ", "r", "ʀ", "s", "ꜱ", "t", "ᴛ", "u", "ᴜ",
+	"v", "ᴠ", "w", "ᴡ", "x", "x", "y", "ʏ", "z", "ᴢ",
+)
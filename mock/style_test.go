@@ -0,0 +1,41 @@
+package mock
+
+import (
+	"testing"
+)
+
+func TestStyles(t *testing.T) {
+	tests := []struct {
+		style string
+		in    string
+		want  string
+	}{
+		{"alternating", "hello world", "hElLo WoRlD"},
+		{"alternating", "a1b2", "a1B2"},
+		{"clap", "you can't do that", "YOU \U0001F44F CAN'T \U0001F44F DO \U0001F44F THAT"},
+		{"clap", "  padded  ", "  PADDED  "},
+		{"clap", "two\nlines", "TWO\nLINES"},
+		{"vaporwave", "abc 123!", "ａｂｃ １２３！"},
+		{"smallcaps", "Small Caps", "ꜱᴍᴀʟʟ ᴄᴀᴘꜱ"},
+		{"whisper", "QUIET Please", "quiet please"},
+	}
+	for _, tt := range tests {
+		style, ok := LookupStyle(tt.style)
+		if !ok {
+			t.Fatalf("LookupStyle(%q) failed", tt.style)
+		}
+		m := New(PlainTokenizer).WithStyle(style)
+		if got := m.MockSeed(tt.in, 0); got != tt.want {
+			t.Errorf("%s: MockSeed(%q) = %q, want %q", tt.style, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLookupStyleIgnoresCase(t *testing.T) {
+	if s, ok := LookupStyle("ClAp"); !ok || s != Clap {
+		t.Errorf("LookupStyle(%q) = %v, %v, want the clap style", "ClAp", s, ok)
+	}
+	if _, ok := LookupStyle("nonexistent"); ok {
+		t.Errorf("LookupStyle(%q) succeeded", "nonexistent")
+	}
+}
@@ -0,0 +1,118 @@
+package mock
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Token is a piece of text produced by a Tokenizer. Only tokens with Mock
+// set are transformed by a Mocker. Mockable tokens are single grapheme
+// clusters, and Cased is set if the grapheme has upper and lower case forms.
+type Token struct {
+	Text  string
+	Mock  bool
+	Cased bool
+
+	special unicode.SpecialCase
+}
+
+// Upper returns the token text in upper case.
+func (t Token) Upper() string {
+	var s string
+	if t.special != nil {
+		s = strings.ToUpperSpecial(t.special, t.Text)
+	} else {
+		s = strings.ToUpper(t.Text)
+	}
+	// ß has no single letter upper case mapping
+	return strings.Replace(s, "ß", "SS", -1)
+}
+
+// Lower returns the token text in lower case.
+func (t Token) Lower() string {
+	if t.special != nil {
+		return strings.ToLowerSpecial(t.special, t.Text)
+	}
+	return strings.ToLower(t.Text)
+}
+
+// Tokenizer splits text into tokens, marking the ones which may be mocked.
+type Tokenizer interface {
+	Tokenize(string) []Token
+}
+
+// TokenizerFunc adapts an ordinary function to the Tokenizer interface.
+type TokenizerFunc func(string) []Token
+
+// Tokenize calls f(s).
+func (f TokenizerFunc) Tokenize(s string) []Token {
+	return f(s)
+}
+
+// regexpTokenizer keeps every match of its regexp verbatim and splits the
+// text between the matches into mockable grapheme clusters.
+type regexpTokenizer struct {
+	re *regexp.Regexp
+}
+
+func (t regexpTokenizer) Tokenize(s string) []Token {
+	var tokens []Token
+	last := 0
+	for _, loc := range t.re.FindAllStringIndex(s, -1) {
+		tokens = appendGraphemes(tokens, s[last:loc[0]])
+		tokens = append(tokens, Token{Text: s[loc[0]:loc[1]]})
+		last = loc[1]
+	}
+	return appendGraphemes(tokens, s[last:])
+}
+
+func appendGraphemes(tokens []Token, s string) []Token {
+	for _, g := range Graphemes(s) {
+		tokens = append(tokens, Token{
+			Text:  g,
+			Mock:  true,
+			Cased: isCased(g),
+		})
+	}
+	return tokens
+}
+
+// NewRegexpTokenizer returns a Tokenizer which leaves text matching re
+// untouched and mocks everything else.
+func NewRegexpTokenizer(re *regexp.Regexp) Tokenizer {
+	return regexpTokenizer{re}
+}
+
+var (
+	// PlainTokenizer mocks everything except whitespace.
+	PlainTokenizer = NewRegexpTokenizer(regexp.MustCompile("\\s+"))
+
+	// SlackTokenizer understands Slack's mrkdwn format. It leaves code
+	// blocks, inline code, emoji shortcodes, HTML escaped entities, <...>
+	// links and user, channel and subteam mentions untouched, along with the
+	// bold, italic and strikethrough markers around the mocked text.
+	SlackTokenizer = NewRegexpTokenizer(regexp.MustCompile(strings.Join([]string{
+		// code blocks
+		"```[\\s\\S]*?```",
+		// inline code
+		"`[^`\\n]+`",
+		// emoji shortcodes, including skin tones like :wave::skin-tone-2:
+		":[a-z0-9_+'-]+:",
+		"&amp;|&lt;|&gt;",
+		// links and mentions
+		"<[^>\\n]+>",
+		// formatting markers
+		"[*_~]",
+		"\\s+",
+	}, "|")))
+
+	twitterTokenizer = NewRegexpTokenizer(regexp.MustCompile("@\\w{1,15}|https://t.co/\\w+|\\s+"))
+
+	// TwitterTokenizer unescapes tweet text and leaves Twitter usernames and
+	// t.co links untouched.
+	TwitterTokenizer Tokenizer = TokenizerFunc(func(s string) []Token {
+		return twitterTokenizer.Tokenize(html.UnescapeString(s))
+	})
+)
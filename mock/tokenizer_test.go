@@ -0,0 +1,68 @@
+package mock
+
+import (
+	"reflect"
+	"testing"
+)
+
+func tokenTexts(tokens []Token) []string {
+	var res []string
+	for _, tok := range tokens {
+		if tok.Mock {
+			res = append(res, tok.Text)
+		} else {
+			res = append(res, "["+tok.Text+"]")
+		}
+	}
+	return res
+}
+
+func TestSlackTokenizer(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"hi", []string{"h", "i"}},
+		{"a `b c`", []string{"a", "[ ]", "[`b c`]"}},
+		{"```x\ny```z", []string{"[```x\ny```]", "z"}},
+		{"ok :smile:", []string{"o", "k", "[ ]", "[:smile:]"}},
+		{":wave::skin-tone-2:", []string{"[:wave:]", "[:skin-tone-2:]"}},
+		{"a&amp;b", []string{"a", "[&amp;]", "b"}},
+		{"&lt;&gt;", []string{"[&lt;]", "[&gt;]"}},
+		{"hey <@U123ABC|bob>", []string{"h", "e", "y", "[ ]", "[<@U123ABC|bob>]"}},
+		{"<https://example.com|ex> <#C123>", []string{"[<https://example.com|ex>]", "[ ]", "[<#C123>]"}},
+		{"*bold* _it_", []string{"[*]", "b", "o", "l", "d", "[*]", "[ ]", "[_]", "i", "t", "[_]"}},
+		{"~no~", []string{"[~]", "n", "o", "[~]"}},
+	}
+	for _, tt := range tests {
+		if got := tokenTexts(SlackTokenizer.Tokenize(tt.in)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SlackTokenizer.Tokenize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTwitterTokenizer(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"@spongemock hi", []string{"[@spongemock]", "[ ]", "h", "i"}},
+		{"a &amp; b", []string{"a", "[ ]", "&", "[ ]", "b"}},
+		{"see https://t.co/AbC123", []string{"s", "e", "e", "[ ]", "[https://t.co/AbC123]"}},
+		{"a@b", []string{"a", "[@b]"}},
+	}
+	for _, tt := range tests {
+		if got := tokenTexts(TwitterTokenizer.Tokenize(tt.in)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("TwitterTokenizer.Tokenize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSlackMockLeavesMarkupAlone(t *testing.T) {
+	m := New(SlackTokenizer).WithStyle(Clap)
+	in := "*look* at <@U123ABC|bob> :smile: `code` &amp; more"
+	want := "*LOOK* \U0001F44F AT \U0001F44F <@U123ABC|bob> \U0001F44F :smile: \U0001F44F `code` \U0001F44F &amp; \U0001F44F MORE"
+	if got := m.Mock(in); got != want {
+		t.Errorf("Mock(%q) = %q, want %q", in, got, want)
+	}
+}
@@ -0,0 +1,187 @@
+// Package secret encrypts credentials for storage using envelope encryption,
+// so that the keys protecting them can be rotated.
+//
+// Each value is encrypted with its own random data key, and the data key is
+// encrypted with a key from a Keyring. Rotating keys only requires
+// re-encrypting data keys.
+package secret
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const keySize = 32
+
+var encoding = base64.RawStdEncoding
+
+// Keyring holds the keys used to encrypt data keys. The first key is the
+// current key, which is used for encryption. The others can only be used
+// for decryption.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// ParseKeyring parses keys of the form id:key, where key is 32 bytes encoded
+// in base64. Keys are separated by commas or newlines, and lines starting
+// with # are ignored.
+func ParseKeyring(s string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	sc := bufio.NewScanner(strings.NewReader(strings.Replace(s, ",", "\n", -1)))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return nil, errors.New("keys must be of the form id:key")
+		}
+		id := line[:i]
+		if _, ok := k.keys[id]; ok {
+			return nil, fmt.Errorf("duplicate key %s", id)
+		}
+		raw, err := base64.StdEncoding.DecodeString(line[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("key %s is not base64 encoded: %s", id, err)
+		}
+		if len(raw) != keySize {
+			return nil, fmt.Errorf("key %s must be %d bytes, got %d", id, keySize, len(raw))
+		}
+		aead, err := newAEAD(raw)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %s", id, err)
+		}
+		k.keys[id] = aead
+		if k.current == "" {
+			k.current = id
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if k.current == "" {
+		return nil, errors.New("no keys given")
+	}
+	return k, nil
+}
+
+// CurrentID returns the ID of the key used for encryption.
+func (k *Keyring) CurrentID() string {
+	return k.current
+}
+
+// Encrypt encrypts plaintext, authenticating it along with the additional
+// data, which must be given again to decrypt it. It returns the ID of the key
+// used and the sealed value.
+func (k *Keyring) Encrypt(plaintext, additionalData []byte) (string, string, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", "", err
+	}
+	data, err := seal(aead, plaintext, additionalData)
+	if err != nil {
+		return "", "", err
+	}
+	return k.wrap(dataKey, data)
+}
+
+// Decrypt decrypts a value sealed with the given key.
+func (k *Keyring) Decrypt(keyID, sealed string, additionalData []byte) ([]byte, error) {
+	dataKey, data, err := k.unwrap(keyID, sealed)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := open(aead, data, additionalData)
+	if err != nil {
+		return nil, errors.New("value could not be decrypted")
+	}
+	return plaintext, nil
+}
+
+// Rotate re-encrypts the data key of a sealed value with the current key,
+// returning the new key ID and sealed value.
+func (k *Keyring) Rotate(keyID, sealed string) (string, string, error) {
+	dataKey, data, err := k.unwrap(keyID, sealed)
+	if err != nil {
+		return "", "", err
+	}
+	return k.wrap(dataKey, data)
+}
+
+// wrap encrypts a data key with the current key and joins it with the data
+// it encrypted.
+func (k *Keyring) wrap(dataKey, data []byte) (string, string, error) {
+	wrapped, err := seal(k.keys[k.current], dataKey, []byte(k.current))
+	if err != nil {
+		return "", "", err
+	}
+	return k.current, encoding.EncodeToString(wrapped) + "." + encoding.EncodeToString(data), nil
+}
+
+// unwrap decrypts the data key of a sealed value and returns it along with
+// the encrypted data.
+func (k *Keyring) unwrap(keyID, sealed string) ([]byte, []byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown key %s", keyID)
+	}
+	parts := strings.Split(sealed, ".")
+	if len(parts) != 2 {
+		return nil, nil, errors.New("malformed sealed value")
+	}
+	wrapped, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, errors.New("malformed sealed value")
+	}
+	data, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, errors.New("malformed sealed value")
+	}
+	dataKey, err := open(aead, wrapped, []byte(keyID))
+	if err != nil {
+		return nil, nil, fmt.Errorf("data key could not be decrypted with key %s", keyID)
+	}
+	return dataKey, data, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with a random nonce, which is prepended to the
+// ciphertext.
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed value is too short")
+	}
+	n := aead.NonceSize()
+	return aead.Open(nil, sealed[:n], sealed[n:], additionalData)
+}